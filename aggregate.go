@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/xml"
+	"sort"
+	"sync"
+)
+
+// orderAggregate is the running total of every order recorded since start,
+// behind the headline stats unless a stats window is set
+type orderAggregate struct {
+	mu       sync.Mutex
+	total    int
+	revenue  float64
+	byStatus map[OrderStatus]int
+	byTenant map[string]int
+}
+
+// TenantOrders is the number of orders recorded for one tenant
+type TenantOrders struct {
+	Tenant string `json:"tenant" xml:"name,attr"`
+	Orders int    `json:"orders" xml:",chardata"`
+}
+
+// otherStatus groups unknown statuses in StatusCounts
+const otherStatus = "other"
+
+// StatusCounts is the number of orders recorded per status. Every known
+// status is present, and unknown ones are grouped under "other".
+type StatusCounts map[string]int
+
+// MarshalXML writes the counts as <status name="...">n</status> elements in
+// name order; encoding/xml cannot encode maps itself.
+func (c StatusCounts) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, name := range names {
+		status := xml.StartElement{
+			Name: xml.Name{Local: "status"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}},
+		}
+		if err := e.EncodeElement(c[name], status); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func newOrderAggregate() *orderAggregate {
+	return &orderAggregate{
+		byStatus: make(map[OrderStatus]int),
+		byTenant: make(map[string]int),
+	}
+}
+
+func (a *orderAggregate) add(order Order) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.total++
+	if countsAsRevenue(order) {
+		a.revenue += order.Amount
+	}
+	a.byStatus[order.Status]++
+	a.byTenant[order.Tenant]++
+}
+
+// reset forgets every recorded order.
+func (a *orderAggregate) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.total, a.revenue = 0, 0
+	a.byStatus = make(map[OrderStatus]int)
+	a.byTenant = make(map[string]int)
+}
+
+// fill copies the totals and the figures derived from them into a stats
+// snapshot. The error rate comes from the sliding window instead.
+func (a *orderAggregate) fill(stats *Stats) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats.TotalOrders = a.total
+	stats.LifetimeOrders = a.total
+	stats.TotalRevenue = a.revenue
+	stats.ActiveOrders = a.byStatus[StatusPending] + a.byStatus[StatusProcessing]
+	stats.StatusCounts = make(StatusCounts, len(orderStatuses)+1)
+	for _, status := range orderStatuses {
+		stats.StatusCounts[string(status)] = 0
+	}
+	for status, count := range a.byStatus {
+		if _, ok := stats.StatusCounts[string(status)]; ok {
+			stats.StatusCounts[string(status)] = count
+		} else {
+			// Passthrough statuses are unbounded; group them
+			stats.StatusCounts[otherStatus] += count
+		}
+	}
+	if a.total > 0 {
+		stats.AverageOrder = a.revenue / float64(a.total)
+	}
+	stats.OrdersByTenant = make([]TenantOrders, 0, len(a.byTenant))
+	for tenant, orders := range a.byTenant {
+		stats.OrdersByTenant = append(stats.OrdersByTenant, TenantOrders{Tenant: tenant, Orders: orders})
+	}
+	sort.Slice(stats.OrdersByTenant, func(i, j int) bool {
+		return stats.OrdersByTenant[i].Tenant < stats.OrdersByTenant[j].Tenant
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Alert states
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// Alert is pushed to WebSocket clients when a threshold alert changes state
+type Alert struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Since     time.Time `json:"since"`
+}
+
+// thresholdAlert fires once its value has stayed above the threshold for the
+// sustain duration, and resolves when the value drops back. It reports each
+// transition exactly once so a flapping or persistently high value does not
+// flood clients. A zero threshold disables the alert.
+type thresholdAlert struct {
+	name      string
+	threshold float64
+	sustain   time.Duration
+
+	aboveSince time.Time
+	firing     bool
+}
+
+func (a *thresholdAlert) evaluate(value float64, now time.Time) *Alert {
+	if a.threshold <= 0 || value <= a.threshold {
+		a.aboveSince = time.Time{}
+		if !a.firing {
+			return nil
+		}
+		a.firing = false
+		return a.alert(alertResolved, value, now)
+	}
+
+	if a.aboveSince.IsZero() {
+		a.aboveSince = now
+	}
+	if a.firing || now.Sub(a.aboveSince) < a.sustain {
+		return nil
+	}
+	a.firing = true
+	return a.alert(alertFiring, value, a.aboveSince)
+}
+
+func (a *thresholdAlert) alert(state string, value float64, since time.Time) *Alert {
+	return &Alert{
+		Name:      a.name,
+		State:     state,
+		Value:     value,
+		Threshold: a.threshold,
+		Since:     since,
+	}
+}
+
+// AlertThresholds are the tunable alert settings. Zero thresholds disable
+// the corresponding alert.
+type AlertThresholds struct {
+	ErrorRate                float64 `json:"error_rate"`
+	ErrorRateSustainSeconds  float64 `json:"error_rate_sustain_seconds"`
+	QueueDepth               int     `json:"queue_depth"`
+	QueueDepthSustainSeconds float64 `json:"queue_depth_sustain_seconds"`
+}
+
+// maxAlertSustain bounds the sustain durations, well short of where the
+// seconds overflow a time.Duration
+const maxAlertSustain = 24 * time.Hour
+
+// maxAlertThresholdsBody bounds the size of a PUT /api/admin/alerts body.
+const maxAlertThresholdsBody = 4 << 10
+
+// validate checks the settings. The comparisons are written so NaN fails
+// them too.
+func (t AlertThresholds) validate() error {
+	if !(t.ErrorRate >= 0 && t.ErrorRate <= 1) {
+		return fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if t.QueueDepth < 0 {
+		return fmt.Errorf("queue_depth must not be negative")
+	}
+	for name, seconds := range map[string]float64{
+		"error_rate_sustain_seconds":  t.ErrorRateSustainSeconds,
+		"queue_depth_sustain_seconds": t.QueueDepthSustainSeconds,
+	} {
+		if !(seconds >= 0 && seconds <= maxAlertSustain.Seconds()) {
+			return fmt.Errorf("%s must be between 0 and %.0f", name, maxAlertSustain.Seconds())
+		}
+	}
+	return nil
+}
+
+// alertMonitor evaluates the threshold alerts against each stats snapshot
+type alertMonitor struct {
+	mu         sync.Mutex
+	now        func() time.Time
+	errorRate  *thresholdAlert
+	queueDepth *thresholdAlert
+}
+
+func newAlertMonitor(cfg Config) *alertMonitor {
+	m := &alertMonitor{
+		now:        time.Now,
+		errorRate:  &thresholdAlert{name: "error_rate"},
+		queueDepth: &thresholdAlert{name: "queue_depth"},
+	}
+	m.setThresholds(AlertThresholds{
+		ErrorRate:                cfg.ErrorRateThreshold,
+		ErrorRateSustainSeconds:  cfg.ErrorRateSustain.Seconds(),
+		QueueDepth:               cfg.QueueDepthThreshold,
+		QueueDepthSustainSeconds: cfg.QueueDepthSustain.Seconds(),
+	})
+	return m
+}
+
+// thresholds returns the settings currently in effect.
+func (m *alertMonitor) thresholds() AlertThresholds {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return AlertThresholds{
+		ErrorRate:                m.errorRate.threshold,
+		ErrorRateSustainSeconds:  m.errorRate.sustain.Seconds(),
+		QueueDepth:               int(m.queueDepth.threshold),
+		QueueDepthSustainSeconds: m.queueDepth.sustain.Seconds(),
+	}
+}
+
+// setThresholds applies new settings atomically with respect to evaluate.
+// Alert state is kept, so a firing alert resolves on the next evaluation if
+// the new threshold is no longer exceeded.
+func (m *alertMonitor) setThresholds(t AlertThresholds) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errorRate.threshold = t.ErrorRate
+	m.errorRate.sustain = secondsDuration(t.ErrorRateSustainSeconds)
+	m.queueDepth.threshold = float64(t.QueueDepth)
+	m.queueDepth.sustain = secondsDuration(t.QueueDepthSustainSeconds)
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// evaluate returns the alerts that fired or resolved with this snapshot.
+func (m *alertMonitor) evaluate(stats Stats) []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var alerts []Alert
+	if a := m.errorRate.evaluate(stats.ErrorRate, now); a != nil {
+		alerts = append(alerts, *a)
+	}
+	if a := m.queueDepth.evaluate(float64(stats.QueueDepth), now); a != nil {
+		alerts = append(alerts, *a)
+	}
+	return alerts
+}
+
+// handleAlertThresholds reads (GET) or replaces (PUT) the alert thresholds.
+// Fields omitted from a PUT body keep their current value.
+func handleAlertThresholds(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, hub.alerts.thresholds())
+	case http.MethodPut:
+		thresholds := hub.alerts.thresholds()
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAlertThresholdsBody))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&thresholds); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if err := thresholds.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hub.alerts.setThresholds(thresholds)
+		log.Printf("Alert thresholds updated: %+v", thresholds)
+		writeJSON(w, http.StatusOK, hub.alerts.thresholds())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for alert evaluation
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestAlertMonitor(t *testing.T, args ...string) (*alertMonitor, *fakeClock) {
+	t.Helper()
+
+	cfg, err := loadConfig(args)
+	if err != nil {
+		t.Fatalf("loadConfig(%q): %v", args, err)
+	}
+	clock := &fakeClock{now: testNow}
+	m := newAlertMonitor(cfg)
+	m.now = clock.Now
+	return m, clock
+}
+
+func TestQueueDepthAlert(t *testing.T) {
+	m, clock := newTestAlertMonitor(t, "--queue-depth-threshold", "100", "--queue-depth-sustain", "30s")
+	deep := Stats{QueueDepth: 150}
+
+	if alerts := m.evaluate(deep); len(alerts) != 0 {
+		t.Fatalf("alerted as soon as the threshold was crossed: %+v", alerts)
+	}
+	clock.advance(29 * time.Second)
+	if alerts := m.evaluate(deep); len(alerts) != 0 {
+		t.Fatalf("alerted before the sustain duration: %+v", alerts)
+	}
+
+	clock.advance(time.Second)
+	alerts := m.evaluate(deep)
+	if len(alerts) != 1 {
+		t.Fatalf("after 30s above the threshold got %d alerts, want 1", len(alerts))
+	}
+	want := Alert{Name: "queue_depth", State: alertFiring, Value: 150, Threshold: 100, Since: testNow}
+	if alerts[0] != want {
+		t.Errorf("alert = %+v, want %+v", alerts[0], want)
+	}
+
+	// A firing alert is not repeated
+	clock.advance(time.Minute)
+	if alerts := m.evaluate(Stats{QueueDepth: 500}); len(alerts) != 0 {
+		t.Errorf("firing alert repeated: %+v", alerts)
+	}
+
+	clock.advance(time.Second)
+	alerts = m.evaluate(Stats{QueueDepth: 100})
+	if len(alerts) != 1 || alerts[0].State != alertResolved || alerts[0].Name != "queue_depth" {
+		t.Fatalf("at the threshold got %+v, want queue_depth resolved", alerts)
+	}
+	if alerts := m.evaluate(Stats{QueueDepth: 0}); len(alerts) != 0 {
+		t.Errorf("resolved alert repeated: %+v", alerts)
+	}
+}
+
+func TestQueueDepthAlertRestartsSustainAfterDip(t *testing.T) {
+	m, clock := newTestAlertMonitor(t, "--queue-depth-threshold", "100", "--queue-depth-sustain", "30s")
+
+	m.evaluate(Stats{QueueDepth: 150})
+	clock.advance(20 * time.Second)
+	m.evaluate(Stats{QueueDepth: 50})
+	clock.advance(20 * time.Second)
+	if alerts := m.evaluate(Stats{QueueDepth: 150}); len(alerts) != 0 {
+		t.Fatalf("alerted although the queue dipped below the threshold: %+v", alerts)
+	}
+	clock.advance(30 * time.Second)
+	if alerts := m.evaluate(Stats{QueueDepth: 150}); len(alerts) != 1 {
+		t.Errorf("got %d alerts 30s after the queue rose again, want 1", len(alerts))
+	}
+}
+
+func TestQueueDepthAlertDisabled(t *testing.T) {
+	m, clock := newTestAlertMonitor(t)
+
+	for i := 0; i < 3; i++ {
+		if alerts := m.evaluate(Stats{QueueDepth: 1 << 20}); len(alerts) != 0 {
+			t.Fatalf("disabled alert fired: %+v", alerts)
+		}
+		clock.advance(time.Hour)
+	}
+}
+
+// putAlertThresholds sends body to the alert threshold API as a client with
+// the given bearer token.
+func putAlertThresholds(hub *Hub, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPut, "/api/admin/alerts", strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleAlertThresholds(hub, w, r)
+	})(rec, r)
+	return rec
+}
+
+func TestAlertThresholdsAPI(t *testing.T) {
+	hub, _ := newTestHub(t, "--error-rate-threshold", "0.5", "--error-rate-sustain", "0s", "--queue-depth-threshold", "100")
+	clock := &fakeClock{now: testNow}
+	hub.alerts.now = clock.Now
+	elevated := Stats{ErrorRate: 0.3}
+
+	if alerts := hub.alerts.evaluate(elevated); len(alerts) != 0 {
+		t.Fatalf("alerted below the configured threshold: %+v", alerts)
+	}
+
+	rec := putAlertThresholds(hub, "", `{"error_rate": 0.2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var updated AlertThresholds
+	decodeJSON(t, rec, &updated)
+	want := AlertThresholds{ErrorRate: 0.2, QueueDepth: 100, QueueDepthSustainSeconds: 30}
+	if updated != want {
+		t.Errorf("updated thresholds = %+v, want %+v with the omitted fields kept", updated, want)
+	}
+
+	clock.advance(time.Second)
+	alerts := hub.alerts.evaluate(elevated)
+	if len(alerts) != 1 || alerts[0].Name != "error_rate" || alerts[0].Threshold != 0.2 {
+		t.Errorf("after lowering the threshold got %+v, want error_rate firing at 0.2", alerts)
+	}
+}
+
+func TestAlertThresholdsAPIRejectsInvalid(t *testing.T) {
+	hub, _ := newTestHub(t, "--error-rate-threshold", "0.5")
+	before := hub.alerts.thresholds()
+
+	for _, body := range []string{
+		`{"error_rate": 1.5}`,
+		`{"error_rate": -0.1}`,
+		`{"queue_depth": -1}`,
+		`{"queue_depth_sustain_seconds": -5}`,
+		`{"error_rate": "high"}`,
+		`not json`,
+		// Would overflow time.Duration and fire at once
+		`{"error_rate_sustain_seconds": 1e300}`,
+		`{"queue_depth_sustain_seconds": 86401}`,
+		`{"error_rate": 0.1, "error_rate_sustain": 30}`,
+		`{"error_rate": 0.1, "padding": "` + strings.Repeat("x", maxAlertThresholdsBody) + `"}`,
+	} {
+		if rec := putAlertThresholds(hub, "", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if after := hub.alerts.thresholds(); after != before {
+		t.Errorf("rejected updates changed the thresholds from %+v to %+v", before, after)
+	}
+}
+
+func TestAlertThresholdsValidate(t *testing.T) {
+	if err := (AlertThresholds{ErrorRate: 1, ErrorRateSustainSeconds: 86400, QueueDepthSustainSeconds: 86400}).validate(); err != nil {
+		t.Errorf("limits rejected: %v", err)
+	}
+	for _, thresholds := range []AlertThresholds{
+		{ErrorRate: math.NaN()},
+		{ErrorRateSustainSeconds: math.NaN()},
+		{QueueDepthSustainSeconds: math.Inf(1)},
+	} {
+		if err := thresholds.validate(); err == nil {
+			t.Errorf("%+v accepted", thresholds)
+		}
+	}
+}
+
+func TestAlertThresholdsAPIRequiresAuth(t *testing.T) {
+	authToken = "secret"
+	t.Cleanup(func() { authToken = "" })
+	hub, _ := newTestHub(t, "--error-rate-threshold", "0.5")
+
+	for _, token := range []string{"", "wrong"} {
+		if rec := putAlertThresholds(hub, token, `{"error_rate": 0.1}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want 401", token, rec.Code)
+		}
+	}
+	if got := hub.alerts.thresholds().ErrorRate; got != 0.5 {
+		t.Errorf("unauthorized update applied: error_rate = %v", got)
+	}
+	if rec := putAlertThresholds(hub, "secret", `{"error_rate": 0.1}`); rec.Code != http.StatusOK {
+		t.Errorf("with the token: status = %d, want 200", rec.Code)
+	}
+}
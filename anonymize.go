@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// anonymizedPrefix starts every anonymized customer, to tell them apart
+// from raw identifiers at a glance
+const anonymizedPrefix = "anon_"
+
+// anonymizedBytes is how much of the digest is kept: 64 bits, enough that
+// distinct customers in the aggregates don't collide
+const anonymizedBytes = 8
+
+// anonymizedCheckBytes is the length of the check value after the digest
+const anonymizedCheckBytes = 4
+
+// customerAnonymizer replaces customer identifiers with a salted hash. The
+// hash is stable for a given salt, so repeat-customer and session stats still
+// work on anonymized orders. A nil anonymizer leaves customers as they are.
+type customerAnonymizer struct {
+	salt []byte
+}
+
+// newCustomerAnonymizer returns nil when anonymization is disabled. An empty
+// salt is replaced by a random one, which changes the hashes on every restart
+// and differs between instances.
+func newCustomerAnonymizer(enabled bool, salt string) *customerAnonymizer {
+	if !enabled {
+		return nil
+	}
+	if salt == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			panic(fmt.Sprintf("generating customer salt: %v", err))
+		}
+		return &customerAnonymizer{salt: b}
+	}
+	return &customerAnonymizer{salt: []byte(salt)}
+}
+
+// anonymize replaces the order's customer with its hash and marks the order
+// anonymized, so an instance receiving it over the sink doesn't hash the
+// hash. The mark is only trusted on a customer that is one of our hashes:
+// any producer can set it.
+func (a *customerAnonymizer) anonymize(order *Order) {
+	if a == nil || (order.Anonymized && a.hashed(order.Customer)) {
+		return
+	}
+	order.Customer = a.hash(order.Customer)
+	order.Anonymized = true
+}
+
+// hash returns the customer's salted hash, followed by a check value that
+// lets instances sharing the salt recognize it.
+func (a *customerAnonymizer) hash(customer string) string {
+	if customer == "" {
+		return ""
+	}
+	digest := a.digest([]byte(customer))[:anonymizedBytes]
+	check := a.digest(append([]byte(anonymizedPrefix), digest...))[:anonymizedCheckBytes]
+	return anonymizedPrefix + hex.EncodeToString(digest) + hex.EncodeToString(check)
+}
+
+// hashed reports whether customer is a hash made with our salt. Without the
+// salt the check value can't be forged, so a raw identifier passed off as
+// a hash is still hashed.
+func (a *customerAnonymizer) hashed(customer string) bool {
+	raw, err := hex.DecodeString(strings.TrimPrefix(customer, anonymizedPrefix))
+	if err != nil || !strings.HasPrefix(customer, anonymizedPrefix) || len(raw) != anonymizedBytes+anonymizedCheckBytes {
+		return false
+	}
+	digest, check := raw[:anonymizedBytes], raw[anonymizedBytes:]
+	want := a.digest(append([]byte(anonymizedPrefix), digest...))[:anonymizedCheckBytes]
+	return subtle.ConstantTimeCompare(check, want) == 1
+}
+
+// digest returns the salted SHA-256 of data.
+func (a *customerAnonymizer) digest(data []byte) []byte {
+	h := sha256.New()
+	h.Write(a.salt)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// scrub anonymizes the customer in a payload that failed to decode as an
+// order, before it is kept anywhere. A payload that isn't even a JSON object
+// can't be searched for the customer, so it is withheld.
+func (a *customerAnonymizer) scrub(payload []byte) []byte {
+	if a == nil {
+		return payload
+	}
+	withheld := []byte(fmt.Sprintf("withheld: %d bytes that are not a JSON object", len(payload)))
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+		return withheld
+	}
+	var anonymized bool
+	json.Unmarshal(fields["anonymized"], &anonymized)
+	for key, raw := range fields {
+		// Matched the way encoding/json matches it to Order.Customer
+		if !strings.EqualFold(key, "customer") {
+			continue
+		}
+		var customer string
+		if json.Unmarshal(raw, &customer) != nil {
+			customer = string(raw)
+		}
+		if anonymized && a.hashed(customer) {
+			continue
+		}
+		fields[key], _ = json.Marshal(a.hash(customer))
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return withheld
+	}
+	return out
+}
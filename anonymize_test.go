@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+const rawCustomer = "alice@example.com"
+
+// readRaw reads one message off a WebSocket connection as sent.
+func readRaw(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(data)
+}
+
+func TestAnonymizedBroadcast(t *testing.T) {
+	hub, _ := newTestHub(t, "--anonymize-customers", "--customer-salt", "pepper", "--high-value-threshold", "100")
+	base := serveTestHub(t, hub)
+	stats := dialTestHub(t, base+"/ws")
+	orders := dialTestHub(t, base+"/ws/orders")
+	readRaw(t, stats) // initial snapshot
+	waitFor(t, "both clients to register", func() bool { return hub.ConnectionCount() == 2 })
+
+	order := testOrder("1", StatusCompleted, 500)
+	order.Customer = rawCustomer
+	hub.recordOrder(order, testNow, 0)
+	publishStatsMarker(t, hub)
+
+	// The order event, then the high-value event and the stats after it
+	payloads := []string{readRaw(t, orders), readRaw(t, stats), readRaw(t, stats)}
+	for _, payload := range payloads {
+		if strings.Contains(payload, rawCustomer) {
+			t.Errorf("broadcast %s carries the raw customer", payload)
+		}
+	}
+	var env Envelope
+	if err := json.Unmarshal([]byte(payloads[0]), &env); err != nil {
+		t.Fatal(err)
+	}
+	var sent Order
+	if err := json.Unmarshal(env.Data, &sent); err != nil {
+		t.Fatal(err)
+	}
+	want := newCustomerAnonymizer(true, "pepper").hash(rawCustomer)
+	if sent.Customer != want || !sent.Anonymized {
+		t.Errorf("broadcast customer %q, anonymized %t; want %q, true", sent.Customer, sent.Anonymized, want)
+	}
+	if !strings.Contains(payloads[1], want) {
+		t.Errorf("high-value event %s does not carry the hash", payloads[1])
+	}
+}
+
+func TestAnonymizedStorage(t *testing.T) {
+	hub, _ := newTestHub(t, "--anonymize-customers")
+	hub.history.ping()
+
+	order := testOrder("1", StatusCompleted, 10)
+	order.Customer = rawCustomer
+	hub.recordOrder(order, testNow, 0)
+	persistPending(hub)
+
+	for _, tt := range []struct {
+		path    string
+		handler func(*Hub, http.ResponseWriter, *http.Request)
+	}{
+		{"/api/orders", handleOrders},
+		{"/api/orders/recent", handleRecentOrders},
+		{"/orders/1", handleGetOrder},
+	} {
+		rec := httptest.NewRecorder()
+		tt.handler(hub, rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", tt.path, rec.Code)
+		}
+		if body := rec.Body.String(); strings.Contains(body, rawCustomer) || !strings.Contains(body, anonymizedPrefix) {
+			t.Errorf("%s = %s, want the customer anonymized", tt.path, body)
+		}
+	}
+}
+
+func TestAnonymizedDeadLetters(t *testing.T) {
+	hub, _ := newTestHub(t, "--anonymize-customers")
+	hub.history.ping()
+
+	// Decoded but rejected, and not decodable as an order at all
+	order := testOrder("1", "bogus", 10)
+	order.Customer = rawCustomer
+	hub.recordOrder(order, testNow, 0)
+	hub.deadLetter(errorUnmarshal, []byte(`{"id":"2","Customer":"`+rawCustomer+`","status":7}`), errors.New("bad status"))
+	hub.deadLetter(errorUnmarshal, []byte(`not json `+rawCustomer), errors.New("bad JSON"))
+
+	rec := httptest.NewRecorder()
+	handleDeadLetters(hub, rec, httptest.NewRequest(http.MethodGet, "/orders/deadletter", nil))
+	var list DeadLetterList
+	decodeJSON(t, rec, &list)
+	if len(list.Entries) != 3 {
+		t.Fatalf("got %d dead letters, want 3", len(list.Entries))
+	}
+	for _, entry := range list.Entries {
+		if strings.Contains(entry.Payload, rawCustomer) {
+			t.Errorf("dead letter %s carries the raw customer", entry.Payload)
+		}
+	}
+	if !strings.HasPrefix(list.Entries[0].Payload, "withheld:") {
+		t.Errorf("unparseable payload kept as %q, want it withheld", list.Entries[0].Payload)
+	}
+}
+
+func TestCustomerHash(t *testing.T) {
+	a := newCustomerAnonymizer(true, "pepper")
+	if a.hash(rawCustomer) != a.hash(rawCustomer) {
+		t.Error("hash is not stable for a salt")
+	}
+	if got := a.hash(rawCustomer); !strings.HasPrefix(got, anonymizedPrefix) || len(got) != len(anonymizedPrefix)+2*(anonymizedBytes+anonymizedCheckBytes) {
+		t.Errorf("hash = %q, want %s and %d hex digits", got, anonymizedPrefix, 2*(anonymizedBytes+anonymizedCheckBytes))
+	}
+	if !a.hashed(a.hash(rawCustomer)) {
+		t.Error("hash not recognized as one")
+	}
+	for _, customer := range []string{rawCustomer, "", anonymizedPrefix, "anon_0123456789abcdef01234567", newCustomerAnonymizer(true, "salt").hash(rawCustomer)} {
+		if a.hashed(customer) {
+			t.Errorf("%q recognized as a hash of ours", customer)
+		}
+	}
+	if a.hash(rawCustomer) == newCustomerAnonymizer(true, "salt").hash(rawCustomer) {
+		t.Error("different salts give the same hash")
+	}
+	if a.hash("") != "" {
+		t.Error("empty customer hashed")
+	}
+
+	// Already anonymized orders, e.g. from another instance, are not rehashed
+	order := Order{Customer: rawCustomer}
+	a.anonymize(&order)
+	hashed := order.Customer
+	a.anonymize(&order)
+	if order.Customer != hashed {
+		t.Errorf("anonymized twice: %q, then %q", hashed, order.Customer)
+	}
+
+	var disabled *customerAnonymizer
+	order = Order{Customer: rawCustomer}
+	disabled.anonymize(&order)
+	if order.Customer != rawCustomer || order.Anonymized {
+		t.Errorf("disabled anonymizer changed the order to %+v", order)
+	}
+}
+
+func TestSpoofedAnonymizedFlag(t *testing.T) {
+	hub, _ := newTestHub(t, "--anonymize-customers", "--customer-salt", "pepper")
+	hub.history.ping()
+	sub := newSubscriber(hub)
+	hub.workers.start(hub, 1)
+	t.Cleanup(func() { hub.workers.shutdown(context.Background()) })
+	relayed := newCustomerAnonymizer(true, "pepper").hash("bob@example.com")
+
+	// A producer claiming its raw customer is already hashed, and an order
+	// another instance with the same salt hashed before relaying it
+	sub.handle(&redis.Message{Channel: "orders", Payload: `{"id":"1","customer":"` + rawCustomer + `","amount":10,"status":"completed","anonymized":true}`})
+	sub.handle(&redis.Message{Channel: "orders", Payload: `{"id":"2","customer":"` + relayed + `","amount":10,"status":"completed","anonymized":true}`})
+	hub.deadLetter(errorUnmarshal, []byte(`{"id":"3","customer":"`+rawCustomer+`","anonymized":true,"status":7}`), errors.New("bad status"))
+	waitFor(t, "both orders", func() bool { return hub.generateStats().LifetimeOrders == 2 })
+
+	byID := map[string]Order{}
+	for _, order := range hub.orders.snapshot() {
+		byID[order.ID] = order
+	}
+	if got := byID["1"].Customer; got != newCustomerAnonymizer(true, "pepper").hash(rawCustomer) {
+		t.Errorf("spoofed order customer = %q, want it hashed", got)
+	}
+	if got := byID["2"].Customer; got != relayed {
+		t.Errorf("relayed order customer = %q, want the relayed hash %q kept", got, relayed)
+	}
+
+	rec := httptest.NewRecorder()
+	handleDeadLetters(hub, rec, httptest.NewRequest(http.MethodGet, "/orders/deadletter", nil))
+	if strings.Contains(rec.Body.String(), rawCustomer) {
+		t.Errorf("dead letters %s carry the raw customer", rec.Body)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencySample is one order's processing latency
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// latencyBuffer keeps the most recent order latencies in a fixed-size ring.
+// Unlike the histogram it is never sampled, so windowed scores stay exact.
+type latencyBuffer struct {
+	mu      sync.RWMutex
+	samples []latencySample
+	next    int
+	full    bool
+}
+
+func newLatencyBuffer(capacity int) *latencyBuffer {
+	return &latencyBuffer{samples: make([]latencySample, capacity)}
+}
+
+func (b *latencyBuffer) add(at time.Time, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.samples[b.next] = latencySample{at: at, latency: latency}
+	b.next = (b.next + 1) % len(b.samples)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// reset forgets every recorded latency.
+func (b *latencyBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	clear(b.samples)
+	b.next, b.full = 0, false
+}
+
+// between returns the latencies recorded in [start, end).
+func (b *latencyBuffer) between(start, end time.Time) []time.Duration {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	n := b.next
+	if b.full {
+		n = len(b.samples)
+	}
+	var out []time.Duration
+	for _, s := range b.samples[:n] {
+		if !s.at.Before(start) && s.at.Before(end) {
+			out = append(out, s.latency)
+		}
+	}
+	return out
+}
+
+// Apdex is the response of /api/apdex. Score is null when no latencies were
+// recorded in the window.
+type Apdex struct {
+	Window           string   `json:"window"`
+	ThresholdSeconds float64  `json:"threshold_seconds"`
+	Samples          int      `json:"samples"`
+	Satisfied        int      `json:"satisfied"`
+	Tolerating       int      `json:"tolerating"`
+	Frustrated       int      `json:"frustrated"`
+	Score            *float64 `json:"score"`
+}
+
+// apdex scores latencies against threshold t: faster than t is satisfied,
+// faster than 4t tolerating, anything slower frustrated.
+func apdex(latencies []time.Duration, t time.Duration) Apdex {
+	result := Apdex{ThresholdSeconds: t.Seconds(), Samples: len(latencies)}
+	for _, latency := range latencies {
+		switch {
+		case latency < t:
+			result.Satisfied++
+		case latency < 4*t:
+			result.Tolerating++
+		default:
+			result.Frustrated++
+		}
+	}
+	if result.Samples > 0 {
+		score := (float64(result.Satisfied) + float64(result.Tolerating)/2) / float64(result.Samples)
+		result.Score = &score
+	}
+	return result
+}
+
+func handleApdex(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	threshold := 0.5
+	if param := r.URL.Query().Get("threshold"); param != "" {
+		t, err := strconv.ParseFloat(param, 64)
+		if err != nil || t <= 0 {
+			writeError(w, http.StatusBadRequest, "threshold must be a positive number of seconds")
+			return
+		}
+		threshold = t
+	}
+	window, ok := parseWindow(r, 5*time.Minute)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "window must be a positive duration")
+		return
+	}
+
+	end := hub.now()
+	result := apdex(hub.latencies.between(end.Add(-window), end), secondsDuration(threshold))
+	result.Window = window.String()
+	writeJSON(w, http.StatusOK, result)
+}
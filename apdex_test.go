@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ms(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+
+func TestApdex(t *testing.T) {
+	tests := []struct {
+		name           string
+		latencies      []time.Duration
+		wantSatisfied  int
+		wantTolerating int
+		wantFrustrated int
+		wantScore      float64
+	}{
+		{
+			name:          "all satisfied",
+			latencies:     []time.Duration{ms(10), ms(100), ms(499)},
+			wantSatisfied: 3,
+			wantScore:     1,
+		},
+		{
+			name:           "thresholds are exclusive",
+			latencies:      []time.Duration{ms(500), ms(1999), ms(2000)},
+			wantTolerating: 2,
+			wantFrustrated: 1,
+			wantScore:      1.0 / 3,
+		},
+		{
+			name:           "mixed",
+			latencies:      []time.Duration{ms(100), ms(100), ms(100), ms(100), ms(100), ms(100), ms(800), ms(1500), ms(3000), ms(9000)},
+			wantSatisfied:  6,
+			wantTolerating: 2,
+			wantFrustrated: 2,
+			wantScore:      0.7,
+		},
+		{
+			name:           "all frustrated",
+			latencies:      []time.Duration{ms(2000), time.Minute},
+			wantFrustrated: 2,
+			wantScore:      0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := apdex(tt.latencies, ms(500))
+			if got.Samples != len(tt.latencies) || got.Satisfied != tt.wantSatisfied || got.Tolerating != tt.wantTolerating || got.Frustrated != tt.wantFrustrated {
+				t.Errorf("got %+v, want %d/%d/%d satisfied/tolerating/frustrated", got, tt.wantSatisfied, tt.wantTolerating, tt.wantFrustrated)
+			}
+			if got.Score == nil || fmt.Sprintf("%.6f", *got.Score) != fmt.Sprintf("%.6f", tt.wantScore) {
+				t.Errorf("score = %v, want %v", got.Score, tt.wantScore)
+			}
+			if got.ThresholdSeconds != 0.5 {
+				t.Errorf("threshold_seconds = %v, want 0.5", got.ThresholdSeconds)
+			}
+		})
+	}
+}
+
+func TestApdexNoSamples(t *testing.T) {
+	if got := apdex(nil, ms(500)); got.Score != nil || got.Samples != 0 {
+		t.Errorf("got %+v, want a null score", got)
+	}
+}
+
+func getApdex(hub *Hub, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleApdex(hub, rec, httptest.NewRequest(http.MethodGet, "/api/apdex"+query, nil))
+	return rec
+}
+
+func TestHandleApdex(t *testing.T) {
+	hub, _ := newTestHub(t)
+	// Within the default five minute window: two satisfied and one frustrated
+	// at the default 0.5s threshold, the two only tolerating at 0.1s
+	hub.recordOrder(testOrder("1", StatusCompleted, 10), testNow.Add(-time.Minute), ms(200))
+	hub.recordOrder(testOrder("2", StatusCompleted, 10), testNow.Add(-time.Minute), ms(300))
+	hub.recordOrder(testOrder("3", StatusCompleted, 10), testNow.Add(-time.Minute), ms(2500))
+	// Outside it
+	hub.recordOrder(testOrder("4", StatusCompleted, 10), testNow.Add(-time.Hour), ms(5000))
+
+	for _, tt := range []struct {
+		query       string
+		wantSamples int
+		wantScore   float64
+	}{
+		{query: "", wantSamples: 3, wantScore: 2.0 / 3},
+		{query: "?threshold=0.1", wantSamples: 3, wantScore: 1.0 / 3},
+		{query: "?threshold=2&window=2h", wantSamples: 4, wantScore: 0.75},
+	} {
+		rec := getApdex(hub, tt.query)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want 200", tt.query, rec.Code)
+		}
+		var got Apdex
+		decodeJSON(t, rec, &got)
+		if got.Samples != tt.wantSamples || got.Score == nil || fmt.Sprintf("%.6f", *got.Score) != fmt.Sprintf("%.6f", tt.wantScore) {
+			t.Errorf("%q: got %d samples scoring %v, want %d scoring %v", tt.query, got.Samples, got.Score, tt.wantSamples, tt.wantScore)
+		}
+	}
+
+	for _, query := range []string{"?threshold=0", "?threshold=-1", "?threshold=fast", "?window=never"} {
+		if rec := getApdex(hub, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestLatencyBufferKeepsMostRecent(t *testing.T) {
+	b := newLatencyBuffer(3)
+	for i := 1; i <= 5; i++ {
+		b.add(testNow.Add(time.Duration(i)*time.Second), ms(i))
+	}
+
+	got := b.between(testNow, testNow.Add(time.Minute))
+	if len(got) != 3 {
+		t.Fatalf("kept %v, want the last 3 latencies", got)
+	}
+	seen := map[time.Duration]bool{}
+	for _, latency := range got {
+		seen[latency] = true
+	}
+	if !seen[ms(3)] || !seen[ms(4)] || !seen[ms(5)] {
+		t.Errorf("kept %v, want 3ms, 4ms and 5ms", got)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPISpec documents the REST routes. Update it alongside any change to
+// their parameters or response types.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// apiError is the body returned by the REST API on failure
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Response encode error: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// Media types offered by the negotiated endpoints
+const (
+	mediaJSON = "application/json"
+	mediaXML  = "application/xml"
+)
+
+// negotiateFormat picks JSON or XML from the Accept header, preferring JSON
+// on ties and when the header is absent. ok is false when the client accepts
+// neither.
+func negotiateFormat(r *http.Request) (media string, ok bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return mediaJSON, true
+	}
+
+	jsonQ, xmlQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "*/*", "application/*":
+			jsonQ, xmlQ = maxQ(jsonQ, q), maxQ(xmlQ, q)
+		case mediaJSON:
+			jsonQ = maxQ(jsonQ, q)
+		case mediaXML, "text/xml":
+			xmlQ = maxQ(xmlQ, q)
+		}
+	}
+
+	switch {
+	case jsonQ <= 0 && xmlQ <= 0:
+		return "", false
+	case xmlQ > jsonQ:
+		return mediaXML, true
+	default:
+		return mediaJSON, true
+	}
+}
+
+func maxQ(current, q float64) float64 {
+	if q > current {
+		return q
+	}
+	return current
+}
+
+// writeNegotiated encodes v as JSON or XML per the request's Accept header,
+// answering 406 when neither is acceptable.
+func writeNegotiated(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	media, ok := negotiateFormat(r)
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, "supported media types are application/json and application/xml")
+		return
+	}
+	if media == mediaJSON {
+		writeJSON(w, status, v)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaXML)
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Response encode error: %v", err)
+	}
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+func handleStats(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeNegotiated(w, r, http.StatusOK, hub.currentStats())
+}
+
+// PolledStats is the response of /stats: the snapshot WebSocket clients get,
+// stamped with the server time so pollers can tell when it is stale
+type PolledStats struct {
+	Stats
+	ServerTime time.Time `json:"server_time"`
+	Paused     bool      `json:"paused"`
+}
+
+// handlePolledStats serves stats to clients that poll instead of holding a
+// WebSocket open.
+func handlePolledStats(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, PolledStats{Stats: hub.currentStats(), ServerTime: hub.now(), Paused: hub.paused.Load()})
+}
+
+// Limits of the /api/orders listing
+const (
+	defaultOrderListLimit = 50
+	maxOrderListLimit     = 1000
+)
+
+// OrderList is the response of /api/orders
+type OrderList struct {
+	XMLName xml.Name `json:"-" xml:"orders"`
+	Count   int      `json:"count" xml:"count,attr"`
+	Orders  []Order  `json:"orders" xml:"order"`
+}
+
+// parseLimit reads the limit query parameter, applying the default and
+// clamping it to max.
+func parseLimit(r *http.Request, def, max int) (int, bool) {
+	param := r.URL.Query().Get("limit")
+	if param == "" {
+		return def, true
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 {
+		return 0, false
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, true
+}
+
+func handleOrders(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit, ok := parseLimit(r, defaultOrderListLimit, maxOrderListLimit)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+
+	// Newest first
+	buffered := hub.orders.snapshot()
+	orders := make([]Order, 0, limit)
+	for i := len(buffered) - 1; i >= 0 && len(orders) < limit; i-- {
+		orders = append(orders, buffered[i])
+	}
+	writeNegotiated(w, r, http.StatusOK, OrderList{Count: len(orders), Orders: orders})
+}
+
+// Connections is the response of /connections
+type Connections struct {
+	Count       int      `json:"count"`
+	RemoteAddrs []string `json:"remote_addrs,omitempty"`
+}
+
+// handleConnections reports the connected WebSocket clients, with their
+// remote addresses when ?verbose=true.
+func handleConnections(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	verbose, err := strconv.ParseBool(r.URL.Query().Get("verbose"))
+	if err != nil && r.URL.Query().Get("verbose") != "" {
+		writeError(w, http.StatusBadRequest, "verbose must be a boolean")
+		return
+	}
+	if !verbose {
+		writeJSON(w, http.StatusOK, Connections{Count: hub.ConnectionCount()})
+		return
+	}
+
+	clients := hub.SnapshotConnections()
+	resp := Connections{Count: len(clients), RemoteAddrs: make([]string, 0, len(clients))}
+	for _, c := range clients {
+		resp.RemoteAddrs = append(resp.RemoteAddrs, c.conn.RemoteAddr().String())
+	}
+	sort.Strings(resp.RemoteAddrs)
+	writeJSON(w, http.StatusOK, resp)
+}
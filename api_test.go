@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// openAPIDocument is the part of an OpenAPI 3 document the tests check
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]json.RawMessage `json:"responses"`
+}
+
+var openAPIMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleOpenAPI(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+
+	var doc openAPIDocument
+	decodeJSON(t, rec, &doc)
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want a 3.x version", doc.OpenAPI)
+	}
+	if doc.Info.Title == "" || doc.Info.Version == "" {
+		t.Errorf("info = %+v, want a title and version", doc.Info)
+	}
+	if len(doc.Paths) == 0 {
+		t.Fatal("no paths documented")
+	}
+	for path, item := range doc.Paths {
+		if !strings.HasPrefix(path, "/") {
+			t.Errorf("path %q does not start with /", path)
+		}
+		for method, op := range item {
+			if method == "parameters" {
+				continue
+			}
+			if !openAPIMethods[method] {
+				t.Errorf("%s: unknown method %q", path, method)
+			}
+			if len(op.Responses) == 0 {
+				t.Errorf("%s %s: no responses", method, path)
+			}
+		}
+	}
+
+	// Every schema reference resolves
+	refs := regexp.MustCompile(`"\$ref":\s*"#/components/schemas/([^"]+)"`)
+	for _, m := range refs.FindAllStringSubmatch(rec.Body.String(), -1) {
+		if _, ok := doc.Components.Schemas[m[1]]; !ok {
+			t.Errorf("reference to undefined schema %q", m[1])
+		}
+	}
+}
+
+// TestOpenAPICoversRoutes fails when a REST route is registered in main
+// without being documented.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	source, err := os.ReadFile("main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := regexp.MustCompile(`\bapi\("([^"]+)"`).FindAllStringSubmatch(string(source), -1)
+	if len(routes) == 0 {
+		t.Fatal("no routes found in main.go")
+	}
+	for _, m := range routes {
+		route := m[1]
+		if route == "/openapi.json" {
+			continue
+		}
+		if route == "/orders/" {
+			route = "/orders/{id}"
+		}
+		if _, ok := doc.Paths[route]; !ok {
+			t.Errorf("route %s is not in openapi.json", route)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		wantOK bool
+	}{
+		{accept: "", want: mediaJSON, wantOK: true},
+		{accept: "application/json", want: mediaJSON, wantOK: true},
+		{accept: "application/xml", want: mediaXML, wantOK: true},
+		{accept: "text/xml", want: mediaXML, wantOK: true},
+		{accept: "*/*", want: mediaJSON, wantOK: true},
+		{accept: "application/xml, application/json", want: mediaJSON, wantOK: true},
+		{accept: "application/json;q=0.5, application/xml", want: mediaXML, wantOK: true},
+		{accept: "text/html, application/*;q=0.1", want: mediaJSON, wantOK: true},
+		{accept: "text/html"},
+		{accept: "application/json;q=0"},
+		{accept: "application/json;q=oops"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+		r.Header.Set("Accept", tt.accept)
+		got, ok := negotiateFormat(r)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Accept %q: got %q, %t; want %q, %t", tt.accept, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+// getNegotiated requests path from handler with the given Accept header.
+func getNegotiated(hub *Hub, handler func(*Hub, http.ResponseWriter, *http.Request), path, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handler(hub, rec, r)
+	return rec
+}
+
+func TestStatsContentNegotiation(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.recordOrder(testOrder("1", StatusCompleted, 42), testNow, 0)
+
+	rec := getNegotiated(hub, handleStats, "/api/stats", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != mediaJSON {
+		t.Fatalf("default: status %d, Content-Type %q; want 200 JSON", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var stats Stats
+	decodeJSON(t, rec, &stats)
+	if stats.TotalOrders != 1 {
+		t.Errorf("JSON total_orders = %d, want 1", stats.TotalOrders)
+	}
+
+	rec = getNegotiated(hub, handleStats, "/api/stats", "application/xml")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != mediaXML {
+		t.Fatalf("XML: status %d, Content-Type %q; want 200 XML", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(rec.Body.String(), xml.Header) {
+		t.Errorf("XML body lacks the declaration: %q", rec.Body.String())
+	}
+	var doc struct {
+		XMLName      xml.Name `xml:"stats"`
+		TotalOrders  int      `xml:"total_orders"`
+		TotalRevenue float64  `xml:"total_revenue"`
+		StatusCounts []struct {
+			Name  string `xml:"name,attr"`
+			Count int    `xml:",chardata"`
+		} `xml:"status_counts>status"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding XML %q: %v", rec.Body.String(), err)
+	}
+	if doc.TotalOrders != 1 || doc.TotalRevenue != 42 {
+		t.Errorf("XML stats = %+v, want one order of 42", doc)
+	}
+	completed := 0
+	for _, status := range doc.StatusCounts {
+		if status.Name == string(StatusCompleted) {
+			completed = status.Count
+		}
+	}
+	if len(doc.StatusCounts) != len(orderStatuses) || completed != 1 {
+		t.Errorf("XML status counts = %+v, want every status and one completed", doc.StatusCounts)
+	}
+
+	rec = getNegotiated(hub, handleStats, "/api/stats", "text/html")
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("text/html: status %d, want 406", rec.Code)
+	}
+}
+
+func TestOrdersContentNegotiation(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.recordOrder(testOrder("1", StatusPending, 10), testNow, 0)
+	hub.recordOrder(testOrder("2", StatusCompleted, 20), testNow, 0)
+
+	rec := getNegotiated(hub, handleOrders, "/api/orders", "application/json")
+	var list OrderList
+	decodeJSON(t, rec, &list)
+	if list.Count != 2 || list.Orders[0].ID != "2" {
+		t.Errorf("JSON orders = %+v, want both, newest first", list)
+	}
+
+	rec = getNegotiated(hub, handleOrders, "/api/orders?limit=1", "application/xml")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != mediaXML {
+		t.Fatalf("XML: status %d, Content-Type %q; want 200 XML", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	list = OrderList{}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decoding XML %q: %v", rec.Body.String(), err)
+	}
+	if list.Count != 1 || len(list.Orders) != 1 || list.Orders[0].ID != "2" || list.Orders[0].Status != StatusCompleted {
+		t.Errorf("XML orders = %+v, want order 2 only", list)
+	}
+
+	rec = getNegotiated(hub, handleOrders, "/api/orders", "image/png")
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("image/png: status %d, want 406", rec.Code)
+	}
+	var apiErr apiError
+	decodeJSON(t, rec, &apiErr)
+	if apiErr.Error == "" {
+		t.Error("406 response lacks an error message")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Envelope wraps every WebSocket payload, so clients can dispatch on Type
+// and new kinds of message can be added without breaking them. Replay is
+// set to the job ID on messages re-emitted by POST /replay. Seq numbers the
+// order events on /ws/orders, for clients to acknowledge.
+type Envelope struct {
+	Type   string          `json:"type"`
+	Replay string          `json:"replay,omitempty"`
+	Seq    uint64          `json:"seq,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Envelope types
+const (
+	eventStats          = "stats"
+	eventAlert          = "alert"
+	eventHighValueOrder = "high_value_order"
+)
+
+// newEnvelope encodes v as the data of an envelope of the given type.
+func newEnvelope(eventType string, v interface{}) ([]byte, error) {
+	return newSequencedEnvelope(eventType, 0, v)
+}
+
+// newSequencedEnvelope is newEnvelope for an order event numbered seq.
+func newSequencedEnvelope(eventType string, seq uint64, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{Type: eventType, Seq: seq, Data: data})
+}
+
+// statsMessage encodes the current stats for the WebSocket feed.
+func (h *Hub) statsMessage() ([]byte, error) {
+	return newEnvelope(eventStats, h.currentStats())
+}
+
+// markStatsChanged notes that the stats have moved since the last broadcast.
+// It never blocks: a pending mark already covers this change.
+func (h *Hub) markStatsChanged() {
+	select {
+	case h.statsDirty <- struct{}{}:
+	default:
+	}
+}
+
+// broadcastStats coalesces stat changes into at most one broadcast per
+// broadcast interval. The first change arms the timer and later ones ride
+// along, so a burst of orders produces a single snapshot, taken when the
+// timer fires.
+func (h *Hub) broadcastStats(ctx context.Context) {
+	timer := time.NewTimer(h.broadcastInterval)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	armed := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-h.statsDirty:
+			if !armed {
+				timer.Reset(h.broadcastInterval)
+				armed = true
+			}
+		case <-timer.C:
+			armed = false
+			statsJSON, _ := h.statsMessage()
+			h.publish(message{data: statsJSON})
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// orderBuffer keeps the most recently recorded orders in a fixed-size ring
+// for the windowed analytics endpoints
+type orderBuffer struct {
+	mu     sync.RWMutex
+	orders []Order
+	next   int
+	full   bool
+}
+
+func newOrderBuffer(capacity int) *orderBuffer {
+	return &orderBuffer{orders: make([]Order, capacity)}
+}
+
+func (b *orderBuffer) add(order Order) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.orders[b.next] = order
+	b.next = (b.next + 1) % len(b.orders)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// reset forgets every buffered order.
+func (b *orderBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	clear(b.orders)
+	b.next, b.full = 0, false
+}
+
+// snapshot returns the buffered orders in the order they were recorded.
+func (b *orderBuffer) snapshot() []Order {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if !b.full {
+		return append([]Order(nil), b.orders[:b.next]...)
+	}
+	out := make([]Order, 0, len(b.orders))
+	out = append(out, b.orders[b.next:]...)
+	return append(out, b.orders[:b.next]...)
+}
+
+// between returns the buffered orders with a timestamp in [start, end).
+func (b *orderBuffer) between(start, end time.Time) []Order {
+	var out []Order
+	for _, order := range b.snapshot() {
+		if !order.Timestamp.Before(start) && order.Timestamp.Before(end) {
+			out = append(out, order)
+		}
+	}
+	return out
+}
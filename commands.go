@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeHandshakeWait bounds how long echoing a client's close frame may take.
+const closeHandshakeWait = time.Second
+
+// writeWait bounds a single write to a client. A client whose socket stays
+// blocked for longer is dropped, so a stuck peer only ever holds up its own
+// writer goroutine, never the hub.
+const writeWait = 10 * time.Second
+
+// Heartbeat tuning: clients are pinged every pingInterval and dropped when
+// nothing, not even a pong, arrives within pingInterval+pongTimeout.
+const (
+	pingInterval = 30 * time.Second
+	pongTimeout  = 10 * time.Second
+)
+
+// clientCommand is a message sent by a WebSocket client
+type clientCommand struct {
+	Type     string   `json:"type"`
+	Seq      uint64   `json:"seq,omitempty"`
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+// handleCommand applies an inbound client message. Malformed or unknown
+// commands are logged and ignored.
+func (c *client) handleCommand(hub *Hub, data []byte) {
+	var cmd clientCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		log.Printf("Ignoring malformed client command: %v", err)
+		return
+	}
+
+	switch cmd.Type {
+	case "ack":
+		c.ack(cmd.Seq)
+	case "subscribe":
+		filter, err := newStatusFilter(cmd.Statuses)
+		if err != nil {
+			log.Printf("Ignoring subscribe command: %v", err)
+			return
+		}
+		hub.setFilter(c, filter)
+	default:
+		log.Printf("Ignoring unknown client command %q", cmd.Type)
+	}
+}
+
+// ack records that the client has received order events up to seq. The
+// first ack opts the connection into pending-ack tracking. Workers number
+// events before they reach the hub, so they can be delivered slightly out
+// of order and every event up to seq is dropped, not just a leading run.
+func (c *client) ack(seq uint64) {
+	c.acking.Store(true)
+
+	c.ackMu.Lock()
+	defer c.ackMu.Unlock()
+
+	kept := c.unacked[:0]
+	for _, pending := range c.unacked {
+		if pending > seq {
+			kept = append(kept, pending)
+		}
+	}
+	c.unacked = kept
+}
+
+// delivered notes an order event sent to an acking client and returns how
+// many it has not acknowledged.
+func (c *client) delivered(seq uint64) uint64 {
+	c.ackMu.Lock()
+	defer c.ackMu.Unlock()
+
+	c.unacked = append(c.unacked, seq)
+	return uint64(len(c.unacked))
+}
+
+// pendingAcks returns how many order events were sent but not acknowledged.
+func (c *client) pendingAcks() uint64 {
+	c.ackMu.Lock()
+	defer c.ackMu.Unlock()
+
+	return uint64(len(c.unacked))
+}
+
+// statusFilter is the set of order statuses a client wants events for. A nil
+// filter accepts everything.
+type statusFilter map[OrderStatus]bool
+
+func newStatusFilter(statuses []string) (statusFilter, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	filter := make(statusFilter, len(statuses))
+	for _, status := range statuses {
+		parsed, err := ParseStatus(status)
+		if err != nil {
+			return nil, fmt.Errorf("unknown status %q", status)
+		}
+		filter[parsed] = true
+	}
+	return filter, nil
+}
+
+// maxRoomLength bounds the ?room= of a WebSocket connection.
+const maxRoomLength = 64
+
+// inRoom reports whether an order event belongs to the client's room. Rooms
+// only narrow order events; clients without one get every order.
+func (c *client) inRoom(msg message) bool {
+	return c.room == "" || c.room == msg.region
+}
+
+// filterChange is a client's request to replace its filter
+type filterChange struct {
+	client *client
+	filter statusFilter
+}
+
+// queryStatusFilter builds a filter from a request's status parameters, each
+// a comma-separated list.
+func queryStatusFilter(r *http.Request) (statusFilter, error) {
+	var statuses []string
+	for _, param := range r.URL.Query()["status"] {
+		statuses = append(statuses, strings.Split(param, ",")...)
+	}
+	return newStatusFilter(statuses)
+}
+
+// setFilter hands a client's new filter to run(), which applies it from the
+// next message on.
+func (h *Hub) setFilter(c *client, filter statusFilter) {
+	select {
+	case h.refilter <- filterChange{client: c, filter: filter}:
+	case <-h.done:
+	}
+}
+
+// index adds a registered client to the per-status index: the unfiltered set,
+// or the set of each status it filters for. Callers hold h.mu.
+func (h *Hub) index(c *client) {
+	if c.filter == nil {
+		h.unfiltered[c] = true
+		return
+	}
+	for status := range c.filter {
+		clients := h.byStatus[status]
+		if clients == nil {
+			clients = make(map[*client]bool)
+			h.byStatus[status] = clients
+		}
+		clients[c] = true
+	}
+}
+
+// unindex removes a client from the per-status index. Callers hold h.mu.
+func (h *Hub) unindex(c *client) {
+	delete(h.unfiltered, c)
+	for status := range c.filter {
+		delete(h.byStatus[status], c)
+		if len(h.byStatus[status]) == 0 {
+			delete(h.byStatus, status)
+		}
+	}
+}
+
+// handleClose completes the close handshake a client started, echoing its
+// close code, and records why the client left.
+func (c *client) handleClose(code int, text string) error {
+	websocketCloses.WithLabelValues(closeCodeLabel(code)).Inc()
+	log.Printf("Client %s closed the connection: code %d, reason %q", c.conn.RemoteAddr(), code, text)
+
+	reply := []byte{}
+	if code != websocket.CloseNoStatusReceived {
+		reply = websocket.FormatCloseMessage(code, "")
+	}
+	err := c.conn.WriteControl(websocket.CloseMessage, reply, time.Now().Add(closeHandshakeWait))
+	if err != nil && err != websocket.ErrCloseSent {
+		log.Printf("Close handshake with %s failed: %v", c.conn.RemoteAddr(), err)
+	}
+	return nil
+}
+
+// closeCodeLabel bounds the close code label: codes defined by RFC 6455 and
+// its registry are kept, application and unassigned codes are grouped.
+func closeCodeLabel(code int) string {
+	switch {
+	case code >= 1000 && code <= 1015:
+		return strconv.Itoa(code)
+	case code >= 3000 && code <= 4999:
+		return "application"
+	default:
+		return "other"
+	}
+}
+
+// extendReadDeadline gives the peer another heartbeat period to respond.
+func (c *client) extendReadDeadline() {
+	c.conn.SetReadDeadline(time.Now().Add(pingInterval + pongTimeout))
+}
+
+// heartbeat pings the client until stop is closed. A half-open connection
+// never answers, so its read deadline passes and the read loop ends.
+func (c *client) heartbeat(stop <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pongTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeLoop sends queued payloads until run() closes the send channel, then
+// the close frame if run() set one. On a write error the connection is
+// closed, which ends the read loop and unregisters the client; queued
+// payloads are discarded meanwhile.
+func (c *client) writeLoop() {
+	for data := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			websocketSendErrors.Inc()
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Printf("Dropping %s: write blocked for %s", c.conn.RemoteAddr(), writeWait)
+			}
+			c.conn.Close()
+			for range c.send {
+			}
+			return
+		}
+		websocketMessagesSent.Inc()
+	}
+	if c.closing != nil {
+		c.conn.WriteControl(websocket.CloseMessage, c.closing, time.Now().Add(closeHandshakeWait))
+	}
+}
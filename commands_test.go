@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// ackingOrderClient connects to /ws/orders and opts into acknowledgments,
+// returning the connection and the hub's side of it.
+func ackingOrderClient(t *testing.T, hub *Hub) (*websocket.Conn, *client) {
+	t.Helper()
+
+	conn := dialTestHub(t, serveTestHub(t, hub)+"/ws/orders")
+	waitFor(t, "the client to register", func() bool { return hub.ConnectionCount() == 1 })
+	c := hub.SnapshotConnections()[0]
+	sendAck(t, conn, 0)
+	waitFor(t, "acknowledgments to be enabled", c.acking.Load)
+	return conn, c
+}
+
+func sendAck(t *testing.T, conn *websocket.Conn, seq uint64) {
+	t.Helper()
+
+	if err := conn.WriteJSON(clientCommand{Type: "ack", Seq: seq}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAckAdvancesTrackedAck(t *testing.T) {
+	hub, _ := newTestHub(t)
+	conn, c := ackingOrderClient(t, hub)
+
+	var seqs []uint64
+	for i := 1; i <= 3; i++ {
+		hub.recordOrder(testOrder(fmt.Sprint(i), StatusPending, 10), testNow, 0)
+		env := readEnvelope(t, conn)
+		if env.Seq == 0 || (len(seqs) > 0 && env.Seq <= seqs[len(seqs)-1]) {
+			t.Fatalf("order event %d has seq %d after %v", i, env.Seq, seqs)
+		}
+		seqs = append(seqs, env.Seq)
+	}
+	if got := c.pendingAcks(); got != 3 {
+		t.Fatalf("pending acks = %d, want 3", got)
+	}
+
+	sendAck(t, conn, seqs[1])
+	waitFor(t, "the ack of the second event", func() bool { return c.pendingAcks() == 1 })
+
+	// Stale and repeated acks change nothing
+	sendAck(t, conn, seqs[0])
+	sendAck(t, conn, seqs[1])
+	time.Sleep(20 * time.Millisecond)
+	if got := c.pendingAcks(); got != 1 {
+		t.Errorf("after a stale ack pending acks = %d, want 1", got)
+	}
+	sendAck(t, conn, seqs[2])
+	waitFor(t, "the ack of the last event", func() bool { return c.pendingAcks() == 0 })
+}
+
+func TestAckOutOfOrderEvents(t *testing.T) {
+	hub, _ := newTestHub(t)
+	conn, c := ackingOrderClient(t, hub)
+
+	// Workers number events before the hub sees them, so a later number can
+	// be delivered first
+	for _, seq := range []uint64{11, 13, 12, 14} {
+		hub.publish(message{data: []byte(fmt.Sprintf(`{"type":"order","seq":%d}`, seq)), seq: seq, stream: streamOrders})
+		if env := readEnvelope(t, conn); env.Seq != seq {
+			t.Fatalf("got seq %d, want %d", env.Seq, seq)
+		}
+	}
+	waitFor(t, "all four deliveries", func() bool { return c.pendingAcks() == 4 })
+
+	// 12 arrived after 13 but is covered all the same
+	sendAck(t, conn, 12)
+	waitFor(t, "the ack up to 12", func() bool { return c.pendingAcks() == 2 })
+	sendAck(t, conn, 14)
+	waitFor(t, "the ack up to 14", func() bool { return c.pendingAcks() == 0 })
+}
+
+func TestUnackedEventsDisconnect(t *testing.T) {
+	hub, _ := newTestHub(t, "--ack-max-pending", "2")
+	conn, _ := ackingOrderClient(t, hub)
+
+	for i := 1; i <= 3; i++ {
+		hub.recordOrder(testOrder(fmt.Sprint(i), StatusPending, 10), testNow, 0)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				t.Fatal("client with 3 unacknowledged events still connected")
+			}
+			break
+		}
+	}
+	waitFor(t, "the client to unregister", func() bool { return hub.ConnectionCount() == 0 })
+}
+
+func TestNonAckingClientNotTracked(t *testing.T) {
+	hub, _ := newTestHub(t, "--ack-max-pending", "1")
+	conn := dialTestHub(t, serveTestHub(t, hub)+"/ws/orders")
+	waitFor(t, "the client to register", func() bool { return hub.ConnectionCount() == 1 })
+
+	for i := 1; i <= 3; i++ {
+		hub.recordOrder(testOrder(fmt.Sprint(i), StatusPending, 10), testNow, 0)
+		readEnvelope(t, conn)
+	}
+	if c := hub.SnapshotConnections()[0]; c.pendingAcks() != 0 {
+		t.Errorf("pending acks = %d for a client that never acked", c.pendingAcks())
+	}
+}
+
+func TestFilterChangesDuringBroadcasts(t *testing.T) {
+	hub, _ := newTestHub(t)
+	conn := dialTestHub(t, serveTestHub(t, hub)+"/ws/orders")
+	waitFor(t, "the client to register", func() bool { return hub.ConnectionCount() == 1 })
+	c := hub.SnapshotConnections()[0]
+
+	ids := make(chan string, 1000)
+	go func() {
+		for {
+			var env Envelope
+			if err := conn.ReadJSON(&env); err != nil {
+				close(ids)
+				return
+			}
+			var order Order
+			if err := json.Unmarshal(env.Data, &order); err == nil {
+				ids <- order.ID
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			hub.recordOrder(testOrder(fmt.Sprint(i), orderStatuses[i%len(orderStatuses)], 10), testNow, 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		filters := [][]string{{"pending"}, {"completed", "failed"}, nil, {"processing"}}
+		for i := 0; i < 100; i++ {
+			if err := conn.WriteJSON(clientCommand{Type: "subscribe", Statuses: filters[i%len(filters)]}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if err := conn.WriteJSON(clientCommand{Type: "subscribe", Statuses: []string{"completed"}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the last filter to apply", func() bool {
+		hub.mu.RLock()
+		defer hub.mu.RUnlock()
+		return len(c.filter) == 1 && c.filter[StatusCompleted]
+	})
+
+	hub.recordOrder(testOrder("after-pending", StatusPending, 10), testNow, 0)
+	hub.recordOrder(testOrder("after-completed", StatusCompleted, 10), testNow, 0)
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case id, ok := <-ids:
+			if !ok {
+				t.Fatal("connection closed before the last order arrived")
+			}
+			if id == "after-pending" {
+				t.Fatal("pending order delivered after filtering for completed only")
+			}
+			if id == "after-completed" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("completed order not delivered after the filter change")
+		}
+	}
+}
+
+func TestNormalCloseHandshake(t *testing.T) {
+	hub, _ := newTestHub(t)
+	conn := dialTestHub(t, serveTestHub(t, hub)+"/ws")
+	readEnvelope(t, conn) // initial snapshot
+	waitFor(t, "the client to register", func() bool { return hub.ConnectionCount() == 1 })
+	closes := websocketCloses.WithLabelValues("1000")
+	before := testutil.ToFloat64(closes)
+
+	// The default handler would answer the server's echo, which fails once
+	// our own close frame is sent
+	conn.SetCloseHandler(func(code int, text string) error { return nil })
+	frame := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")
+	if err := conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server echoes the close frame before dropping the connection
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Fatalf("read error = %v, want the server's normal close frame", err)
+		}
+		break
+	}
+
+	waitFor(t, "the client to unregister", func() bool { return hub.ConnectionCount() == 0 })
+	if got := testutil.ToFloat64(closes) - before; got != 1 {
+		t.Errorf("websocket_closes_total{code=\"1000\"} rose by %v, want 1", got)
+	}
+}
+
+func TestCloseCodeLabel(t *testing.T) {
+	for code, want := range map[int]string{
+		websocket.CloseNormalClosure:    "1000",
+		websocket.CloseGoingAway:        "1001",
+		websocket.CloseNoStatusReceived: "1005",
+		websocket.CloseTLSHandshake:     "1015",
+		3000:                            "application",
+		4999:                            "application",
+		1016:                            "other",
+		5000:                            "other",
+	} {
+		if got := closeCodeLabel(code); got != want {
+			t.Errorf("closeCodeLabel(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+// deliverNaive is deliver without the per-status index, checking every
+// client's filter, for comparison.
+func (h *Hub) deliverNaive(msg message) (slow []*client) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for c := range h.clients {
+		if c.stream != msg.stream || !c.inRoom(msg) {
+			continue
+		}
+		if msg.status != "" && c.filter != nil && !c.filter[msg.status] {
+			continue
+		}
+		select {
+		case c.send <- msg.data:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	return slow
+}
+
+// indexedOrderClients registers n /ws/orders clients directly with the hub:
+// one in fifty unfiltered, one in fifty filtering for failed orders and the
+// rest for other statuses. It returns the clients a failed order reaches.
+func indexedOrderClients(hub *Hub, n int) map[*client]bool {
+	others := []OrderStatus{StatusPending, StatusProcessing, StatusCompleted}
+	reached := make(map[*client]bool)
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for i := 0; i < n; i++ {
+		c := &client{stream: streamOrders, send: make(chan []byte, 1)}
+		switch i % 50 {
+		case 0:
+			reached[c] = true
+		case 1:
+			c.filter = statusFilter{StatusFailed: true}
+			reached[c] = true
+		default:
+			c.filter = statusFilter{others[i%len(others)]: true}
+		}
+		hub.clients[c] = true
+		hub.index(c)
+	}
+	return reached
+}
+
+func TestIndexedDeliveryMatchesNaive(t *testing.T) {
+	hub, _ := newTestHub(t)
+	reached := indexedOrderClients(hub, 500)
+	msg := message{data: []byte("{}"), stream: streamOrders, status: StatusFailed}
+
+	for name, deliver := range map[string]func(message) []*client{"indexed": hub.deliver, "naive": hub.deliverNaive} {
+		if slow := deliver(msg); len(slow) != 0 {
+			t.Errorf("%s: %d slow clients, want none", name, len(slow))
+		}
+		for c := range hub.clients {
+			select {
+			case <-c.send:
+				if !reached[c] {
+					t.Fatalf("%s: client filtering for %v got a failed order", name, c.filter)
+				}
+			default:
+				if reached[c] {
+					t.Fatalf("%s: client filtering for %v missed a failed order", name, c.filter)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkFilteredBroadcast(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		cfg, err := loadConfig(nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		hub := newHub(cfg, redis.NewClient(&redis.Options{Addr: miniredis.RunT(b).Addr()}))
+		reached := indexedOrderClients(hub, n)
+		msg := message{data: []byte("{}"), stream: streamOrders, status: StatusFailed}
+
+		for _, bb := range []struct {
+			name    string
+			deliver func(message) []*client
+		}{{"indexed", hub.deliver}, {"naive", hub.deliverNaive}} {
+			b.Run(fmt.Sprintf("%s/%d", bb.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					bb.deliver(msg)
+					for c := range reached {
+						<-c.send
+					}
+				}
+			})
+		}
+		hub.Close()
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"compress/flate"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings of the monitor. Every option can be set
+// with a command-line flag or with the environment variable of the same name
+// in upper snake case (--hub-channel-buffer / HUB_CHANNEL_BUFFER); flags win.
+type Config struct {
+	HTTPAddr      string
+	TLSCert       string
+	TLSKey        string
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	RedisChannels commaList
+
+	Sink           string
+	KafkaBrokers   commaList
+	KafkaTopic     string
+	PublishTimeout time.Duration
+
+	SimulateOrders    bool
+	SimulateMode      string
+	SimulateRate      float64
+	SimulateInterval  time.Duration
+	SimulateSeed      int64
+	BroadcastInterval time.Duration
+
+	NodeID             string
+	MetricsNamespace   string
+	ShutdownTimeout    time.Duration
+	HubChannelBuffer   int
+	BroadcastBuffer    int
+	WSDrainGrace       time.Duration
+	IngestRate         float64
+	IngestBurst        int
+	WorkerCount        int
+	OrderQueueSize     int
+	OrderBufferSize    int
+	HistoryQueueSize   int
+	OrderTTL           time.Duration
+	DedupTTL           time.Duration
+	ExportMaxRows      int
+	SessionGap         time.Duration
+	StatsWindow        time.Duration
+	WSCompression      bool
+	WSCompressionLevel int
+	WSReadBuffer       int
+	WSWriteBuffer      int
+	AllowedOrigins     origins
+	AckMaxPending      int
+	ClientSendBuffer   int
+	MaxConnections     int
+
+	AuthToken     string
+	Debug         bool
+	StrictStartup bool
+
+	AnonymizeCustomers bool
+	CustomerSalt       string
+
+	ErrorRateThreshold  float64
+	ErrorRateSustain    time.Duration
+	QueueDepthThreshold int
+	QueueDepthSustain   time.Duration
+
+	SecurityHeaders headerOverrides
+
+	Environment    string
+	DashboardTitle string
+
+	SparklineLength     int
+	SlowStatsThreshold  time.Duration
+	StaleStatsThreshold time.Duration
+	LatencySampleRate   float64
+	LatencyBuckets      buckets
+
+	UnknownStatusPolicy  string
+	UnknownStatusDefault string
+	MaxOrderAge          time.Duration
+	StaleOrderPolicy     string
+
+	HighValueThreshold    float64
+	HighValueMaxPerMinute int
+	HighValueTopK         int
+
+	BaseCurrency  string
+	ExchangeRates staticRates
+
+	TSDBURL       string
+	TSDBToken     string
+	TSDBQueueSize int
+}
+
+func loadConfig(args []string) (Config, error) {
+	var cfg Config
+	fs := flag.NewFlagSet("ecommerce-monitoring", flag.ExitOnError)
+
+	fs.StringVar(&cfg.HTTPAddr, "http-addr", ":8080", "address the HTTP server listens on")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "TLS certificate file; with tls-key, serves HTTPS and WSS")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "TLS private key file")
+	fs.StringVar(&cfg.RedisAddr, "redis-addr", "localhost:6379", "Redis server address")
+	fs.StringVar(&cfg.RedisPassword, "redis-password", "", "Redis password")
+	fs.IntVar(&cfg.RedisDB, "redis-db", 0, "Redis database number")
+	fs.Var(&cfg.RedisChannels, "redis-channels", `comma-separated Redis channels orders are consumed from, one per tenant (default "orders")`)
+	fs.StringVar(&cfg.Sink, "sink", sinkRedis, "where simulated and submitted orders are published: redis or kafka")
+	fs.Var(&cfg.KafkaBrokers, "kafka-brokers", "comma-separated Kafka broker addresses, for the kafka sink")
+	fs.StringVar(&cfg.KafkaTopic, "kafka-topic", "orders", "Kafka topic orders are written to, for the kafka sink")
+	fs.DurationVar(&cfg.PublishTimeout, "publish-timeout", 2*time.Second, "bound on publishing one order to the sink")
+	fs.BoolVar(&cfg.SimulateOrders, "simulate-orders", true, "fabricate a random order every simulate-interval; stats are snapshotted at that interval either way")
+	fs.StringVar(&cfg.SimulateMode, "simulate-mode", simulateUniform, "simulated traffic shape: uniform (one order per simulate-interval) or poisson (bursty, around simulate-rate)")
+	fs.Float64Var(&cfg.SimulateRate, "simulate-rate", 0.5, "mean simulated orders per second in poisson mode")
+	fs.Int64Var(&cfg.SimulateSeed, "simulate-seed", 0, "seed for simulated orders, for reproducible runs (0 seeds from the clock)")
+	fs.DurationVar(&cfg.SimulateInterval, "simulate-interval", 2*time.Second, "interval between simulated orders and stats snapshots")
+	fs.DurationVar(&cfg.BroadcastInterval, "broadcast-interval", 250*time.Millisecond, "minimum interval between stats broadcasts; changes in between are coalesced")
+	fs.StringVar(&cfg.NodeID, "node-id", defaultNodeID(), "identifier stamped on orders processed by this instance")
+	fs.StringVar(&cfg.MetricsNamespace, "metrics-namespace", "", "prefix for every metric name, joined with an underscore (empty keeps the bare names)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long shutdown waits for in-flight work to drain")
+	fs.IntVar(&cfg.HubChannelBuffer, "hub-channel-buffer", 256, "buffer size of the hub register/unregister channels")
+	fs.IntVar(&cfg.BroadcastBuffer, "broadcast-buffer", 256, "messages queued for the hub to broadcast before new ones are dropped")
+	fs.DurationVar(&cfg.WSDrainGrace, "ws-drain-grace", 3*time.Second, "how long shutdown waits for WebSocket clients to disconnect after the close frame")
+	fs.Float64Var(&cfg.IngestRate, "ingest-rate", 50, "POST /orders requests per second allowed per client IP (0 disables limiting)")
+	fs.IntVar(&cfg.IngestBurst, "ingest-burst", 100, "POST /orders requests a client IP may burst above ingest-rate")
+	fs.IntVar(&cfg.WorkerCount, "worker-count", 4, "goroutines recording incoming orders")
+	fs.IntVar(&cfg.OrderQueueSize, "order-queue-size", 1000, "orders queued for the workers before POST /orders answers 503")
+	fs.IntVar(&cfg.OrderBufferSize, "order-buffer-size", 10000, "recent orders kept in memory for the analytics endpoints")
+	fs.DurationVar(&cfg.DedupTTL, "dedup-ttl", time.Hour, "how long an order ID is remembered so redelivered or retried orders are recorded once (0 disables)")
+	fs.DurationVar(&cfg.OrderTTL, "order-ttl", 24*time.Hour, "how long a persisted order stays retrievable from /orders/{id}")
+	fs.IntVar(&cfg.HistoryQueueSize, "history-queue-size", 1000, "order history writes queued for retry while the store is down before dropping the oldest")
+	fs.IntVar(&cfg.ExportMaxRows, "export-max-rows", 100000, "maximum rows returned by an order export")
+	fs.DurationVar(&cfg.StatsWindow, "stats-window", time.Hour, "window total_orders and total_revenue cover (0 is all time; lifetime_orders always is)")
+	fs.DurationVar(&cfg.SessionGap, "session-gap", 30*time.Minute, "inactivity after which a customer's next order starts a new session")
+	fs.Var(&cfg.AllowedOrigins, "allowed-origins", `comma-separated origins allowed to open WebSocket connections and call the REST API, "*" for any (empty allows only the server's own origin)`)
+	fs.BoolVar(&cfg.WSCompression, "ws-compression", false, "negotiate permessage-deflate with WebSocket clients that offer it")
+	fs.IntVar(&cfg.WSCompressionLevel, "ws-compression-level", flate.BestSpeed, "deflate level for compressed connections, -2 (Huffman only) to 9 (best compression); out of range falls back to 1")
+	fs.IntVar(&cfg.WSReadBuffer, "ws-read-buffer", 1024, "WebSocket read buffer size in bytes")
+	fs.IntVar(&cfg.WSWriteBuffer, "ws-write-buffer", 1024, "WebSocket write buffer size in bytes")
+	fs.IntVar(&cfg.MaxConnections, "max-connections", 10000, "WebSocket connections accepted before new ones get 503 (0 is unlimited)")
+	fs.IntVar(&cfg.ClientSendBuffer, "client-send-buffer", 256, "messages queued per WebSocket client before it is dropped as too slow")
+	fs.IntVar(&cfg.AckMaxPending, "ack-max-pending", 100, "unacknowledged order events after which an acknowledging client is disconnected (0 disables)")
+	fs.StringVar(&cfg.AuthToken, "auth-token", "", "bearer token required by protected endpoints (empty disables auth)")
+	fs.BoolVar(&cfg.StrictStartup, "strict-startup", false, "exit when the startup Redis pub/sub self-test fails instead of running without Redis")
+	fs.BoolVar(&cfg.AnonymizeCustomers, "anonymize-customers", false, "replace customer identifiers with a salted hash before they are stored, broadcast, published or used as a metric label")
+	fs.StringVar(&cfg.CustomerSalt, "customer-salt", "", "salt for anonymize-customers; set the same value on every instance (empty picks a random one per process)")
+	fs.BoolVar(&cfg.Debug, "debug", false, "enable the debug endpoints, POST /debug/reset and /debug/pprof/; never in production")
+	fs.Float64Var(&cfg.ErrorRateThreshold, "error-rate-threshold", 0, "error rate (0-1) that raises an alert when exceeded (0 disables)")
+	fs.DurationVar(&cfg.ErrorRateSustain, "error-rate-sustain", 30*time.Second, "how long the error rate must stay above the threshold before alerting")
+	fs.IntVar(&cfg.QueueDepthThreshold, "queue-depth-threshold", 0, "queue depth that raises an alert when exceeded (0 disables)")
+	fs.DurationVar(&cfg.QueueDepthSustain, "queue-depth-sustain", 30*time.Second, "how long queue depth must stay above the threshold before alerting")
+	fs.StringVar(&cfg.Environment, "environment", "", "environment name shown as a banner on the dashboard, e.g. prod or staging")
+	fs.StringVar(&cfg.DashboardTitle, "dashboard-title", "E-commerce Monitoring Dashboard", "title shown on the dashboard")
+	fs.IntVar(&cfg.SparklineLength, "sparkline-length", 20, "number of stats intervals in the order count sparkline")
+	fs.DurationVar(&cfg.SlowStatsThreshold, "slow-stats-threshold", 100*time.Millisecond, "log a warning when computing stats takes longer than this (0 disables)")
+	fs.DurationVar(&cfg.StaleStatsThreshold, "stale-stats-threshold", time.Minute, "flag the stats as stale when no order has been recorded for this long (0 disables)")
+	cfg.LatencyBuckets = defaultLatencyBuckets
+	fs.Var(&cfg.LatencyBuckets, "latency-buckets", "comma-separated ascending upper bounds in seconds for order_processing_latency_seconds")
+	fs.Float64Var(&cfg.LatencySampleRate, "latency-sample-rate", 1.0, "fraction of order latencies observed into the latency histogram")
+	fs.StringVar(&cfg.UnknownStatusPolicy, "unknown-status-policy", unknownStatusReject, "handling of orders with an unknown status: reject, passthrough or map")
+	fs.StringVar(&cfg.UnknownStatusDefault, "unknown-status-default", "processing", "status unknown statuses are mapped to under the map policy")
+	fs.DurationVar(&cfg.MaxOrderAge, "max-order-age", 0, "orders with an older timestamp are rejected or flagged (0 disables)")
+	fs.StringVar(&cfg.StaleOrderPolicy, "stale-order-policy", staleOrderReject, "handling of orders older than max-order-age: reject or flag")
+	fs.Float64Var(&cfg.HighValueThreshold, "high-value-threshold", 0, "order amount above which a high_value_order event is broadcast (0 disables)")
+	fs.IntVar(&cfg.HighValueMaxPerMinute, "high-value-max-per-minute", 0, "high_value_order events broadcast per minute before further ones are suppressed (0 is unlimited)")
+	fs.IntVar(&cfg.HighValueTopK, "high-value-top-k", 10, "largest high-value orders served by /api/orders/high-value")
+	fs.StringVar(&cfg.BaseCurrency, "base-currency", "USD", "currency revenue is converted into")
+	fs.Var(&cfg.ExchangeRates, "exchange-rates", `rates into the base currency as "CUR=rate", comma separated (e.g. "EUR=1.08,GBP=1.27")`)
+	fs.StringVar(&cfg.TSDBURL, "tsdb-url", "", "InfluxDB line protocol write URL stats are exported to (empty disables)")
+	fs.StringVar(&cfg.TSDBToken, "tsdb-token", "", "token sent as \"Authorization: Token ...\" on TSDB writes")
+	fs.IntVar(&cfg.TSDBQueueSize, "tsdb-queue-size", 100, "stats points buffered for the TSDB exporter before dropping")
+	fs.Var(&cfg.SecurityHeaders, "security-header", `response header override as "Name: value", empty value removes it (repeatable, "|" separated)`)
+
+	if err := applyEnv(fs); err != nil {
+		return cfg, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if cfg.HTTPAddr == "" || cfg.RedisAddr == "" {
+		return cfg, fmt.Errorf("http-addr and redis-addr must not be empty")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return cfg, fmt.Errorf("tls-cert and tls-key must be set together")
+	}
+	if cfg.RedisDB < 0 {
+		return cfg, fmt.Errorf("redis-db must not be negative")
+	}
+	if len(cfg.RedisChannels) == 0 {
+		cfg.RedisChannels = commaList{defaultOrdersChannel}
+	}
+	switch cfg.Sink {
+	case sinkRedis:
+	case sinkKafka:
+		if len(cfg.KafkaBrokers) == 0 || cfg.KafkaTopic == "" {
+			return cfg, fmt.Errorf("kafka-brokers and kafka-topic must be set for the kafka sink")
+		}
+	default:
+		return cfg, fmt.Errorf("sink must be redis or kafka, got %q", cfg.Sink)
+	}
+	if cfg.PublishTimeout <= 0 {
+		return cfg, fmt.Errorf("publish-timeout must be positive")
+	}
+	if cfg.SimulateInterval <= 0 {
+		return cfg, fmt.Errorf("simulate-interval must be positive")
+	}
+	if cfg.SimulateMode != simulateUniform && cfg.SimulateMode != simulatePoisson {
+		return cfg, fmt.Errorf("simulate-mode must be uniform or poisson, got %q", cfg.SimulateMode)
+	}
+	if cfg.SimulateRate <= 0 {
+		return cfg, fmt.Errorf("simulate-rate must be positive")
+	}
+	if cfg.BroadcastInterval <= 0 {
+		return cfg, fmt.Errorf("broadcast-interval must be positive")
+	}
+	if cfg.NodeID == "" {
+		return cfg, fmt.Errorf("node-id must not be empty")
+	}
+	if !validMetricsNamespace(cfg.MetricsNamespace) {
+		return cfg, fmt.Errorf("metrics-namespace must be letters, digits and underscores, not starting with a digit, got %q", cfg.MetricsNamespace)
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return cfg, fmt.Errorf("shutdown-timeout must be positive")
+	}
+	if cfg.HubChannelBuffer < 0 {
+		return cfg, fmt.Errorf("hub-channel-buffer must not be negative")
+	}
+	if cfg.BroadcastBuffer < 1 {
+		return cfg, fmt.Errorf("broadcast-buffer must be positive")
+	}
+	if cfg.WSDrainGrace < 0 || cfg.WSDrainGrace >= cfg.ShutdownTimeout {
+		return cfg, fmt.Errorf("ws-drain-grace must not be negative and must be below shutdown-timeout")
+	}
+	if cfg.IngestRate < 0 || cfg.IngestBurst < 1 {
+		return cfg, fmt.Errorf("ingest-rate must not be negative and ingest-burst must be positive")
+	}
+	if cfg.WorkerCount < 1 || cfg.OrderQueueSize < 1 {
+		return cfg, fmt.Errorf("worker-count and order-queue-size must be positive")
+	}
+	if cfg.OrderBufferSize < 1 {
+		return cfg, fmt.Errorf("order-buffer-size must be positive")
+	}
+	if cfg.DedupTTL < 0 {
+		return cfg, fmt.Errorf("dedup-ttl must not be negative")
+	}
+	if cfg.OrderTTL <= 0 {
+		return cfg, fmt.Errorf("order-ttl must be positive")
+	}
+	if cfg.HistoryQueueSize < 1 {
+		return cfg, fmt.Errorf("history-queue-size must be positive")
+	}
+	if cfg.ExportMaxRows < 1 {
+		return cfg, fmt.Errorf("export-max-rows must be positive")
+	}
+	if cfg.StatsWindow < 0 {
+		return cfg, fmt.Errorf("stats-window must not be negative")
+	}
+	if cfg.SessionGap <= 0 {
+		return cfg, fmt.Errorf("session-gap must be positive")
+	}
+	if cfg.WSReadBuffer < 1 || cfg.WSWriteBuffer < 1 {
+		return cfg, fmt.Errorf("ws-read-buffer and ws-write-buffer must be positive")
+	}
+	if cfg.MaxConnections < 0 {
+		return cfg, fmt.Errorf("max-connections must not be negative")
+	}
+	if cfg.ClientSendBuffer < 1 {
+		return cfg, fmt.Errorf("client-send-buffer must be positive")
+	}
+	if cfg.AckMaxPending < 0 {
+		return cfg, fmt.Errorf("ack-max-pending must not be negative")
+	}
+	if !(cfg.ErrorRateThreshold >= 0 && cfg.ErrorRateThreshold <= 1) || cfg.ErrorRateSustain < 0 || cfg.ErrorRateSustain > maxAlertSustain {
+		return cfg, fmt.Errorf("error-rate-threshold must be between 0 and 1 and error-rate-sustain between 0 and %s", maxAlertSustain)
+	}
+	if cfg.QueueDepthThreshold < 0 || cfg.QueueDepthSustain < 0 || cfg.QueueDepthSustain > maxAlertSustain {
+		return cfg, fmt.Errorf("queue-depth-threshold must not be negative and queue-depth-sustain must be between 0 and %s", maxAlertSustain)
+	}
+	if cfg.SparklineLength < 1 || cfg.SparklineLength > maxSparklineLength {
+		return cfg, fmt.Errorf("sparkline-length must be between 1 and %d", maxSparklineLength)
+	}
+	if cfg.StaleStatsThreshold < 0 {
+		return cfg, fmt.Errorf("stale-stats-threshold must not be negative")
+	}
+	if cfg.LatencySampleRate < 0 || cfg.LatencySampleRate > 1 {
+		return cfg, fmt.Errorf("latency-sample-rate must be between 0 and 1")
+	}
+	if cfg.MaxOrderAge < 0 {
+		return cfg, fmt.Errorf("max-order-age must not be negative")
+	}
+	if cfg.StaleOrderPolicy != staleOrderReject && cfg.StaleOrderPolicy != staleOrderFlag {
+		return cfg, fmt.Errorf("stale-order-policy must be reject or flag, got %q", cfg.StaleOrderPolicy)
+	}
+	if cfg.HighValueThreshold < 0 {
+		return cfg, fmt.Errorf("high-value-threshold must not be negative")
+	}
+	if cfg.HighValueMaxPerMinute < 0 {
+		return cfg, fmt.Errorf("high-value-max-per-minute must not be negative")
+	}
+	if cfg.HighValueTopK < 1 {
+		return cfg, fmt.Errorf("high-value-top-k must be positive")
+	}
+	cfg.BaseCurrency = strings.ToUpper(strings.TrimSpace(cfg.BaseCurrency))
+	if cfg.BaseCurrency == "" {
+		return cfg, fmt.Errorf("base-currency must not be empty")
+	}
+	if cfg.TSDBQueueSize < 1 {
+		return cfg, fmt.Errorf("tsdb-queue-size must be positive")
+	}
+	switch cfg.UnknownStatusPolicy {
+	case unknownStatusReject, unknownStatusPassthrough, unknownStatusMap:
+	default:
+		return cfg, fmt.Errorf("unknown-status-policy must be reject, passthrough or map, got %q", cfg.UnknownStatusPolicy)
+	}
+	status, err := ParseStatus(cfg.UnknownStatusDefault)
+	if err != nil {
+		return cfg, fmt.Errorf("unknown-status-default %q is not a known status", cfg.UnknownStatusDefault)
+	}
+	cfg.UnknownStatusDefault = string(status)
+	return cfg, nil
+}
+
+// defaultNodeID is the hostname, which is unique per pod or container.
+func defaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	return host
+}
+
+// validMetricsNamespace reports whether ns can prefix a Prometheus metric
+// name.
+func validMetricsNamespace(ns string) bool {
+	for i, r := range ns {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// applyEnv sets every flag whose environment variable is present.
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, name, setErr)
+		}
+	})
+	return err
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// origins is a comma-separated list of allowed origins
+type origins []string
+
+func (o *origins) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *origins) Set(value string) error {
+	// Replace rather than extend, so the flag overrides ALLOWED_ORIGINS
+	*o = nil
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			*o = append(*o, origin)
+		}
+	}
+	return nil
+}
+
+// buckets is a comma-separated list of ascending histogram bucket bounds
+type buckets []float64
+
+func (b *buckets) String() string {
+	parts := make([]string, len(*b))
+	for i, bound := range *b {
+		parts[i] = strconv.FormatFloat(bound, 'f', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (b *buckets) Set(value string) error {
+	var bounds buckets
+	for _, item := range strings.Split(value, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil || math.IsNaN(bound) {
+			return fmt.Errorf("bucket %q is not a number", item)
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return fmt.Errorf("buckets must be ascending, got %s after %s", item, strconv.FormatFloat(bounds[len(bounds)-1], 'f', -1, 64))
+		}
+		bounds = append(bounds, bound)
+	}
+	*b = bounds
+	return nil
+}
+
+// commaList is a comma-separated list of names, such as Redis channels or
+// Kafka brokers
+type commaList []string
+
+func (c *commaList) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *commaList) Set(value string) error {
+	*c = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*c = append(*c, item)
+		}
+	}
+	return nil
+}
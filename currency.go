@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rateProvider supplies exchange rates into the base currency. Static rates
+// from config are the only provider for now; a live one can be swapped in.
+type rateProvider interface {
+	// rate returns the value of one unit of currency in the base currency
+	rate(currency string) (float64, bool)
+}
+
+// staticRates is a fixed rate table, set as a flag of "CUR=rate" entries
+// separated by commas.
+type staticRates map[string]float64
+
+func (s *staticRates) String() string {
+	parts := make([]string, 0, len(*s))
+	for currency, rate := range *s {
+		parts = append(parts, currency+"="+strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (s *staticRates) Set(value string) error {
+	if *s == nil {
+		*s = make(staticRates)
+	}
+	for _, entry := range strings.Split(value, ",") {
+		currency, val, ok := strings.Cut(entry, "=")
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		rate, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if !ok || currency == "" || err != nil || rate <= 0 {
+			return fmt.Errorf("exchange rate %q is not of the form CUR=positive-rate", entry)
+		}
+		(*s)[currency] = rate
+	}
+	return nil
+}
+
+func (s staticRates) rate(currency string) (float64, bool) {
+	rate, ok := s[currency]
+	return rate, ok
+}
+
+// CurrencyRevenue is the revenue recorded in one currency
+type CurrencyRevenue struct {
+	Currency string  `json:"currency" xml:"code,attr"`
+	Amount   float64 `json:"amount" xml:",chardata"`
+}
+
+// revenueLedger totals revenue per currency and converted into the base
+// currency. Orders without a currency are in the base currency; orders in
+// a currency with no known rate are left out of the base total and counted.
+type revenueLedger struct {
+	mu          sync.Mutex
+	base        string
+	rates       rateProvider
+	byCurrency  map[string]float64
+	baseTotal   float64
+	unconverted int
+}
+
+func newRevenueLedger(base string, rates rateProvider) *revenueLedger {
+	return &revenueLedger{
+		base:       base,
+		rates:      rates,
+		byCurrency: make(map[string]float64),
+	}
+}
+
+func (l *revenueLedger) add(order Order) {
+	if !countsAsRevenue(order) {
+		return
+	}
+	currency := strings.ToUpper(order.Currency)
+	if currency == "" {
+		currency = l.base
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.byCurrency[currency] += order.Amount
+	if currency == l.base {
+		l.baseTotal += order.Amount
+		return
+	}
+	rate, ok := l.rates.rate(currency)
+	if !ok {
+		l.unconverted++
+		ordersUnconverted.Inc()
+		return
+	}
+	l.baseTotal += order.Amount * rate
+}
+
+// reset forgets every recorded order.
+func (l *revenueLedger) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.byCurrency = make(map[string]float64)
+	l.baseTotal, l.unconverted = 0, 0
+}
+
+// fill copies the totals into a stats snapshot.
+func (l *revenueLedger) fill(stats *Stats) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats.BaseCurrency = l.base
+	stats.TotalRevenueBase = l.baseTotal
+	stats.UnconvertedOrders = l.unconverted
+	stats.RevenueByCurrency = make([]CurrencyRevenue, 0, len(l.byCurrency))
+	for currency, amount := range l.byCurrency {
+		stats.RevenueByCurrency = append(stats.RevenueByCurrency, CurrencyRevenue{Currency: currency, Amount: amount})
+	}
+	sort.Slice(stats.RevenueByCurrency, func(i, j int) bool {
+		return stats.RevenueByCurrency[i].Currency < stats.RevenueByCurrency[j].Currency
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func currencyOrder(id, currency string, amount float64) Order {
+	order := testOrder(id, StatusCompleted, amount)
+	order.Currency = currency
+	return order
+}
+
+func TestRevenueConvertedToBaseCurrency(t *testing.T) {
+	hub, _ := newTestHub(t, "--base-currency", "USD", "--exchange-rates", "EUR=1.5,GBP=1.25")
+	before := testutil.ToFloat64(ordersUnconverted)
+
+	for _, order := range []Order{
+		currencyOrder("1", "USD", 100),
+		currencyOrder("2", "eur", 50),
+		currencyOrder("3", "GBP", 20),
+		// Without a currency an order is in the base currency
+		currencyOrder("4", "", 10),
+		// No rate for yen
+		currencyOrder("5", "JPY", 1000),
+	} {
+		hub.recordOrder(order, testNow, 0)
+	}
+
+	stats := hub.generateStats()
+	if stats.BaseCurrency != "USD" {
+		t.Errorf("base_currency = %q, want USD", stats.BaseCurrency)
+	}
+	// 100 + 50*1.5 + 20*1.25 + 10
+	if stats.TotalRevenueBase != 210 {
+		t.Errorf("total_revenue_base = %v, want 210", stats.TotalRevenueBase)
+	}
+	if stats.UnconvertedOrders != 1 {
+		t.Errorf("unconverted_orders = %d, want the yen order", stats.UnconvertedOrders)
+	}
+	if got := testutil.ToFloat64(ordersUnconverted) - before; got != 1 {
+		t.Errorf("orders_unconverted_total rose by %v, want 1", got)
+	}
+	want := "[{EUR 50} {GBP 20} {JPY 1000} {USD 110}]"
+	if got := fmt.Sprint(stats.RevenueByCurrency); got != want {
+		t.Errorf("revenue_by_currency = %s, want %s", got, want)
+	}
+}
+
+func TestStaticRatesFlag(t *testing.T) {
+	var rates staticRates
+	if err := rates.Set("eur=1.08, GBP = 1.27"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rates.Set("JPY=0.0067"); err != nil {
+		t.Fatal(err)
+	}
+	if got := rates.String(); got != "EUR=1.08,GBP=1.27,JPY=0.0067" {
+		t.Errorf("rates = %s, want all three, upper-cased", got)
+	}
+
+	for _, value := range []string{"EUR", "EUR=", "=1.1", "EUR=abc", "EUR=0", "EUR=-1"} {
+		var rates staticRates
+		if err := rates.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RepeatRate is the response of /api/customers/repeat-rate. Rate is null
+// when no customer ordered in the first half of the window.
+type RepeatRate struct {
+	Window             string   `json:"window"`
+	FirstHalfCustomers int      `json:"first_half_customers"`
+	RepeatCustomers    int      `json:"repeat_customers"`
+	Rate               *float64 `json:"rate"`
+	Reason             string   `json:"reason,omitempty"`
+}
+
+// repeatRate computes the share of customers ordering in the first half of
+// [start, end) who ordered again in the second half.
+func repeatRate(orders []Order, start, end time.Time) RepeatRate {
+	mid := start.Add(end.Sub(start) / 2)
+	firstHalf := make(map[string]bool)
+	secondHalf := make(map[string]bool)
+	for _, order := range orders {
+		if order.Timestamp.Before(start) || !order.Timestamp.Before(end) {
+			continue
+		}
+		if order.Timestamp.Before(mid) {
+			firstHalf[order.Customer] = true
+		} else {
+			secondHalf[order.Customer] = true
+		}
+	}
+
+	result := RepeatRate{
+		Window:             end.Sub(start).String(),
+		FirstHalfCustomers: len(firstHalf),
+	}
+	for customer := range firstHalf {
+		if secondHalf[customer] {
+			result.RepeatCustomers++
+		}
+	}
+	if result.FirstHalfCustomers == 0 {
+		result.Reason = "no customers ordered in the first half of the window"
+		return result
+	}
+	rate := float64(result.RepeatCustomers) / float64(result.FirstHalfCustomers)
+	result.Rate = &rate
+	return result
+}
+
+// parseWindow reads the window query parameter, defaulting to def.
+func parseWindow(r *http.Request, def time.Duration) (time.Duration, bool) {
+	param := r.URL.Query().Get("window")
+	if param == "" {
+		return def, true
+	}
+	window, err := time.ParseDuration(param)
+	if err != nil || window <= 0 {
+		return 0, false
+	}
+	return window, true
+}
+
+func handleRepeatRate(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	window, ok := parseWindow(r, time.Hour)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "window must be a positive duration")
+		return
+	}
+
+	end := hub.now()
+	start := end.Add(-window)
+	writeJSON(w, http.StatusOK, repeatRate(hub.orders.between(start, end), start, end))
+}
+
+// topRevenueCustomers is how many customers get their own customer label on
+// customer_revenue_total.
+const topRevenueCustomers = 20
+
+// maxRankedCustomers bounds the least-recently-ordering list customers are
+// ranked by revenue from.
+const maxRankedCustomers = 1000
+
+// otherCustomer is the customer_revenue_total label for customers outside
+// the top earners.
+const otherCustomer = "other"
+
+type rankedCustomer struct {
+	name    string
+	revenue float64
+	// counted is the revenue under the customer's own label
+	counted float64
+}
+
+// customerLabel returns the label value for a labelled customer. A customer
+// named like the overflow label gets an extra leading underscore, so "other"
+// becomes "_other" and "_other" becomes "__other".
+func customerLabel(customer string) string {
+	if strings.TrimLeft(customer, "_") == otherCustomer {
+		return "_" + customer
+	}
+	return customer
+}
+
+// customerRanking tracks revenue for recently active customers in an LRU and
+// keeps the top earners labelled. A customer that overtakes the smallest
+// labelled one takes its place; the displaced series is folded into "other",
+// so customer_revenue_total summed over customers never goes down.
+type customerRanking struct {
+	mu        sync.Mutex
+	recent    *list.List
+	entries   map[string]*list.Element
+	labelled  map[string]bool
+	limit     int
+	maxRanked int
+}
+
+func newCustomerRanking(limit, maxRanked int) *customerRanking {
+	return &customerRanking{
+		recent:    list.New(),
+		entries:   make(map[string]*list.Element),
+		labelled:  make(map[string]bool),
+		limit:     limit,
+		maxRanked: maxRanked,
+	}
+}
+
+// add credits revenue to a customer and adds it to customer_revenue_total
+// under the customer's label, or "other". The counter is updated under the
+// lock so a concurrent displacement can't recreate a folded series.
+func (r *customerRanking) add(customer string, amount float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elem, ok := r.entries[customer]
+	if ok {
+		r.recent.MoveToFront(elem)
+	} else {
+		elem = r.recent.PushFront(&rankedCustomer{name: customer})
+		r.entries[customer] = elem
+		if r.recent.Len() > r.maxRanked {
+			r.evictLocked(r.recent.Back())
+		}
+	}
+	entry := elem.Value.(*rankedCustomer)
+	entry.revenue += amount
+
+	if !r.labelled[customer] && !r.labelLocked(entry) {
+		revenueByCustomer.WithLabelValues(otherCustomer).Add(amount)
+		return
+	}
+	entry.counted += amount
+	revenueByCustomer.WithLabelValues(customerLabel(customer)).Add(amount)
+}
+
+// labelLocked labels a customer if there is room or it overtakes the
+// smallest labelled one, and reports whether it did.
+func (r *customerRanking) labelLocked(entry *rankedCustomer) bool {
+	if len(r.labelled) < r.limit {
+		r.labelled[entry.name] = true
+		return true
+	}
+	var smallest *rankedCustomer
+	for name := range r.labelled {
+		c := r.entries[name].Value.(*rankedCustomer)
+		if smallest == nil || c.revenue < smallest.revenue {
+			smallest = c
+		}
+	}
+	if entry.revenue <= smallest.revenue {
+		return false
+	}
+	r.unlabelLocked(smallest)
+	r.labelled[entry.name] = true
+	return true
+}
+
+// unlabelLocked moves a customer's series into "other".
+func (r *customerRanking) unlabelLocked(c *rankedCustomer) {
+	delete(r.labelled, c.name)
+	revenueByCustomer.WithLabelValues(otherCustomer).Add(c.counted)
+	revenueByCustomer.DeleteLabelValues(customerLabel(c.name))
+	c.counted = 0
+}
+
+// reset forgets every ranked customer. The caller resets
+// customer_revenue_total to match.
+func (r *customerRanking) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.recent.Init()
+	r.entries = make(map[string]*list.Element)
+	r.labelled = make(map[string]bool)
+}
+
+func (r *customerRanking) evictLocked(elem *list.Element) {
+	c := r.recent.Remove(elem).(*rankedCustomer)
+	delete(r.entries, c.name)
+	if r.labelled[c.name] {
+		r.unlabelLocked(c)
+	}
+}
+
+// recordCustomerRevenue adds a completed order to customer_revenue_total.
+func (h *Hub) recordCustomerRevenue(order Order) {
+	// Counters panic on negative additions; Redis orders are not validated
+	if order.Status != StatusCompleted || order.Amount <= 0 {
+		return
+	}
+	h.customers.add(order.Customer, order.Amount)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// customerOrder returns an order by customer placed ago before testNow.
+func customerOrder(id, customer string, ago time.Duration) Order {
+	order := testOrder(id, StatusCompleted, 10)
+	order.Customer = customer
+	order.Timestamp = testNow.Add(-ago)
+	return order
+}
+
+func getRepeatRate(t *testing.T, hub *Hub, query string) (int, RepeatRate) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handleRepeatRate(hub, rec, httptest.NewRequest(http.MethodGet, "/api/customers/repeat-rate"+query, nil))
+	var rate RepeatRate
+	if rec.Code == http.StatusOK {
+		decodeJSON(t, rec, &rate)
+	}
+	return rec.Code, rate
+}
+
+func TestRepeatRate(t *testing.T) {
+	hub, _ := newTestHub(t)
+	for _, order := range []Order{
+		// alice repeats, bob only orders early, carol only late
+		customerOrder("1", "alice", 50*time.Minute),
+		customerOrder("2", "alice", 10*time.Minute),
+		customerOrder("3", "bob", 40*time.Minute),
+		customerOrder("4", "bob", 35*time.Minute),
+		customerOrder("5", "carol", 5*time.Minute),
+		// dave's first order is before the window
+		customerOrder("6", "dave", 2*time.Hour),
+		customerOrder("7", "dave", 20*time.Minute),
+	} {
+		hub.recordOrder(order, order.Timestamp, 0)
+	}
+
+	code, rate := getRepeatRate(t, hub, "?window=1h")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if rate.Window != "1h0m0s" || rate.FirstHalfCustomers != 2 || rate.RepeatCustomers != 1 {
+		t.Errorf("got %+v, want 1 of 2 first-half customers repeating over 1h", rate)
+	}
+	if rate.Rate == nil || *rate.Rate != 0.5 || rate.Reason != "" {
+		t.Errorf("rate = %v, reason %q; want 0.5", rate.Rate, rate.Reason)
+	}
+
+	// Over three hours dave's orders fall in opposite halves too
+	_, rate = getRepeatRate(t, hub, "?window=3h")
+	if rate.FirstHalfCustomers != 1 || rate.RepeatCustomers != 1 || rate.Rate == nil || *rate.Rate != 1 {
+		t.Errorf("over 3h got %+v, want dave alone repeating", rate)
+	}
+}
+
+func TestRepeatRateInsufficientData(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.recordOrder(customerOrder("1", "alice", 10*time.Minute), testNow, 0)
+
+	code, rate := getRepeatRate(t, hub, "")
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if rate.Rate != nil || rate.Reason == "" {
+		t.Errorf("got %+v, want a null rate with a reason", rate)
+	}
+}
+
+func TestRepeatRateRejectsBadWindow(t *testing.T) {
+	hub, _ := newTestHub(t)
+	for _, query := range []string{"?window=soon", "?window=-1h", "?window=0s"} {
+		if code, _ := getRepeatRate(t, hub, query); code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, code)
+		}
+	}
+}
+
+// customerRevenue returns the customer_revenue_total series by label.
+func customerRevenue(t *testing.T) map[string]float64 {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(revenueByCustomer)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			got[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+		}
+	}
+	return got
+}
+
+func TestCustomerRankingFoldsDisplaced(t *testing.T) {
+	revenueByCustomer.Reset()
+	t.Cleanup(revenueByCustomer.Reset)
+	r := newCustomerRanking(2, 3)
+
+	r.add("a", 10)
+	r.add("b", 20)
+	r.add("c", 5) // below both, so counted as other
+	r.add("c", 10)
+	// c overtakes a, and a's series moves into other
+	if got, want := customerRevenue(t), map[string]float64{"b": 20, "c": 10, "other": 15}; !reflect.DeepEqual(got, want) {
+		t.Errorf("customer_revenue_total = %v, want %v", got, want)
+	}
+
+	// d evicts a from the ranking and is counted as other; e evicts b, whose
+	// series folds into other too, and takes the free label
+	r.add("c", 1)
+	r.add("d", 1)
+	r.add("e", 1)
+	if got, want := customerRevenue(t), map[string]float64{"c": 11, "e": 1, "other": 36}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after eviction customer_revenue_total = %v, want %v", got, want)
+	}
+}
+
+func TestCustomerNamedOther(t *testing.T) {
+	revenueByCustomer.Reset()
+	t.Cleanup(revenueByCustomer.Reset)
+	r := newCustomerRanking(2, 10)
+
+	r.add("other", 30)
+	r.add("_other", 20)
+	r.add("a", 5) // below both, so counted as other
+	want := map[string]float64{"_other": 30, "__other": 20, "other": 5}
+	if got := customerRevenue(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("customer_revenue_total = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DashboardConfig is the response of /api/dashboard/config. The dashboard
+// uses it to label the page so environments are not mistaken for each other.
+type DashboardConfig struct {
+	Title       string `json:"title"`
+	Environment string `json:"environment,omitempty"`
+	Color       string `json:"color,omitempty"`
+}
+
+// environmentColor picks the banner color for an environment, reddest for
+// the ones where mistakes hurt most.
+func environmentColor(environment string) string {
+	switch strings.ToLower(environment) {
+	case "":
+		return ""
+	case "prod", "production":
+		return "#c62828"
+	case "staging", "stage":
+		return "#ef6c00"
+	case "dev", "development", "local":
+		return "#2e7d32"
+	default:
+		return "#546e7a"
+	}
+}
+
+func handleDashboardConfig(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, DashboardConfig{
+		Title:       hub.dashboardTitle,
+		Environment: hub.environment,
+		Color:       environmentColor(hub.environment),
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleDashboardConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want DashboardConfig
+	}{
+		{
+			name: "defaults",
+			want: DashboardConfig{Title: "E-commerce Monitoring Dashboard"},
+		},
+		{
+			name: "production",
+			args: []string{"--environment", "prod", "--dashboard-title", "Orders EU"},
+			want: DashboardConfig{Title: "Orders EU", Environment: "prod", Color: "#c62828"},
+		},
+		{
+			name: "staging",
+			args: []string{"--environment", "Staging"},
+			want: DashboardConfig{Title: "E-commerce Monitoring Dashboard", Environment: "Staging", Color: "#ef6c00"},
+		},
+		{
+			name: "unrecognized environment",
+			args: []string{"--environment", "qa-7"},
+			want: DashboardConfig{Title: "E-commerce Monitoring Dashboard", Environment: "qa-7", Color: "#546e7a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, _ := newTestHub(t, tt.args...)
+
+			rec := httptest.NewRecorder()
+			handleDashboardConfig(hub, rec, httptest.NewRequest(http.MethodGet, "/api/dashboard/config", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			var got DashboardConfig
+			decodeJSON(t, rec, &got)
+			if got != tt.want {
+				t.Errorf("config = %+v, want %+v", got, tt.want)
+			}
+			if tt.want.Environment == "" && strings.Contains(rec.Body.String(), "environment") {
+				t.Errorf("body %s includes an environment although none is set", rec.Body)
+			}
+		})
+	}
+}
+
+func TestDashboardLoadsConfig(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleDashboard(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rec.Body.String(), "/api/dashboard/config") {
+		t.Error("dashboard does not fetch /api/dashboard/config")
+	}
+}
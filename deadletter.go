@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// deadLetterKey is the Redis list unprocessable orders are kept in, newest
+// first.
+const deadLetterKey = "orders:deadletter"
+
+// deadLetterLength bounds the dead-letter list.
+const deadLetterLength = 1000
+
+// deadLetterTimeout bounds a dead-letter write, which sits on the
+// subscriber and recording paths.
+const deadLetterTimeout = 500 * time.Millisecond
+
+// DeadLetter is an order that could not be processed, kept for inspection
+type DeadLetter struct {
+	Reason  string    `json:"reason"`
+	Error   string    `json:"error"`
+	Payload string    `json:"payload"`
+	At      time.Time `json:"at"`
+}
+
+// DeadLetterList is the response of /orders/deadletter
+type DeadLetterList struct {
+	Count   int          `json:"count"`
+	Entries []DeadLetter `json:"entries"`
+}
+
+// deadLetter keeps an unprocessable order's payload and why it failed in
+// the dead-letter list, with the customer anonymized when configured.
+func (h *Hub) deadLetter(reason string, payload []byte, err error) {
+	h.writeDeadLetter(reason, h.anonymizer.scrub(payload), err)
+}
+
+// writeDeadLetter appends an entry to the dead-letter list. While the store
+// is down the entry is only counted.
+func (h *Hub) writeDeadLetter(reason string, payload []byte, err error) {
+	ordersDeadLettered.WithLabelValues(reason).Inc()
+	if !h.history.healthy.Load() {
+		return
+	}
+	data, encErr := json.Marshal(DeadLetter{Reason: reason, Error: err.Error(), Payload: string(payload), At: h.now()})
+	if encErr != nil {
+		log.Printf("Dead letter encode error: %v", encErr)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+
+	_, pipeErr := h.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, deadLetterKey, data)
+		pipe.LTrim(ctx, deadLetterKey, 0, deadLetterLength-1)
+		return nil
+	})
+	if pipeErr != nil {
+		log.Printf("Dead letter write failed: %v", pipeErr)
+	}
+}
+
+// deadLetterOrder dead-letters an order that decoded but was rejected.
+func (h *Hub) deadLetterOrder(reason string, order Order, err error) {
+	h.anonymizer.anonymize(&order)
+	payload, encErr := json.Marshal(order)
+	if encErr != nil {
+		log.Printf("Dead letter encode error: %v", encErr)
+		return
+	}
+	h.writeDeadLetter(reason, payload, err)
+}
+
+// handleDeadLetters serves the dead-letter list, newest first.
+func handleDeadLetters(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit, ok := parseLimit(r, defaultOrderListLimit, deadLetterLength)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	if !hub.history.healthy.Load() {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, errStoreUnavailable.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), historyTimeout)
+	defer cancel()
+
+	values, err := hub.redis.LRange(ctx, deadLetterKey, 0, int64(limit)-1).Result()
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	list := DeadLetterList{Entries: make([]DeadLetter, 0, len(values))}
+	for _, value := range values {
+		var entry DeadLetter
+		if json.Unmarshal([]byte(value), &entry) == nil {
+			list.Entries = append(list.Entries, entry)
+		}
+	}
+	list.Count = len(list.Entries)
+	writeJSON(w, http.StatusOK, list)
+}
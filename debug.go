@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resettableCounter is an unlabelled counter /debug/reset can zero, which a
+// plain prometheus.Counter can't be. It is a vector without labels whose one
+// child is recreated on reset, so it is exported from the start like a plain
+// counter.
+type resettableCounter struct {
+	*prometheus.CounterVec
+}
+
+func newResettableCounter(opts prometheus.CounterOpts) resettableCounter {
+	c := resettableCounter{prometheus.NewCounterVec(opts, labelNames())}
+	c.Reset()
+	return c
+}
+
+func (c resettableCounter) Inc() { c.WithLabelValues().Inc() }
+
+func (c resettableCounter) Add(v float64) { c.WithLabelValues().Add(v) }
+
+// Reset zeroes the counter.
+func (c resettableCounter) Reset() {
+	c.CounterVec.Reset()
+	c.WithLabelValues()
+}
+
+// resettableHistogram is the histogram counterpart of resettableCounter.
+type resettableHistogram struct {
+	*prometheus.HistogramVec
+}
+
+func newResettableHistogram(opts prometheus.HistogramOpts) resettableHistogram {
+	h := resettableHistogram{prometheus.NewHistogramVec(opts, labelNames())}
+	h.Reset()
+	return h
+}
+
+func (h resettableHistogram) Observe(v float64) { h.histogram().Observe(v) }
+
+// histogram returns the current histogram, which a reset replaces.
+func (h resettableHistogram) histogram() prometheus.Histogram {
+	return h.WithLabelValues().(prometheus.Histogram)
+}
+
+// Reset empties the histogram.
+func (h resettableHistogram) Reset() {
+	h.HistogramVec.Reset()
+	h.WithLabelValues()
+}
+
+// resettableMetrics returns the metrics /debug/reset zeroes: every counter
+// and histogram. Gauges that mirror live state, such as the connection count
+// or store health, stay as they are. It is a function since orderLatency only
+// exists once registered.
+func resettableMetrics() []resettableMetric {
+	return []resettableMetric{
+		{"orders_total", ordersTotal},
+		{"revenue_total", revenueTotal},
+		{"order_amount", orderAmount},
+		{"order_processing_latency_seconds", orderLatency},
+		{"orders_unknown_status_total", unknownStatusOrders},
+		{"order_processing_errors_total", processingErrors},
+		{"orders_deadlettered_total", ordersDeadLettered},
+		{"orders_too_old_total", ordersTooOld},
+		{"orders_deduplicated_total", ordersDeduplicated},
+		{"orders_unconverted_total", ordersUnconverted},
+		{"order_publish_errors_total", orderPublishErrors},
+		{"high_value_orders_total", highValueOrders},
+		{"high_value_events_suppressed_total", highValueEventsSuppressed},
+		{"history_writes_dropped_total", historyWritesDropped},
+		{"broadcasts_dropped_total", broadcastsDropped},
+		{"hub_panics_total", hubPanics},
+		{"stats_computation_duration_seconds", statsComputationDuration},
+		{"websocket_closes_total", websocketCloses},
+		{"websocket_connection_duration_seconds", websocketConnectionDuration},
+		{"websocket_connections_rejected_total", websocketConnectionsRejected},
+		{"websocket_messages_sent_total", websocketMessagesSent},
+		{"websocket_send_errors_total", websocketSendErrors},
+		{"websocket_slow_clients_dropped_total", websocketSlowClientsDropped},
+		{"tsdb_points_dropped_total", tsdbPointsDropped},
+		{"tsdb_write_errors_total", tsdbWriteErrors},
+	}
+}
+
+type resettableMetric struct {
+	name   string
+	metric interface{ Reset() }
+}
+
+// DebugReset is the response of /debug/reset
+type DebugReset struct {
+	Metrics []string `json:"metrics"`
+	State   []string `json:"state"`
+}
+
+// handleDebugReset zeroes the order metrics, the in-memory aggregates and
+// the dedup marks between load test runs. It is only routed with --debug.
+func handleDebugReset(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// First, so a failure leaves everything as it was: a run reusing order
+	// IDs would otherwise be deduplicated away
+	if err := hub.clearDedup(r.Context()); err != nil {
+		log.Printf("Debug reset failed to clear dedup marks: %v", err)
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, "clearing dedup marks: "+err.Error())
+		return
+	}
+
+	resp := DebugReset{}
+	for _, m := range resettableMetrics() {
+		m.metric.Reset()
+		resp.Metrics = append(resp.Metrics, m.name)
+	}
+	orderErrorRate.Set(0)
+	resp.Metrics = append(resp.Metrics, "order_error_rate")
+
+	// The ranking decides which customers are labelled, so the two go together
+	hub.customers.reset()
+	revenueByCustomer.Reset()
+	resp.Metrics = append(resp.Metrics, "customer_revenue_total")
+
+	hub.aggregate.reset()
+	hub.outcomes.reset()
+	hub.revenue.reset()
+	hub.revenueSeries.reset()
+	hub.orders.reset()
+	hub.latencies.reset()
+	hub.sla.reset()
+	hub.orderCounts.reset()
+	hub.series.reset()
+	resp.State = append(resp.State, "aggregate", "error_rate_window", "revenue", "revenue_series",
+		"orders", "latencies", "sla", "customers", "sparkline", "series", "dedup")
+	if hub.window != nil {
+		hub.window.reset()
+		resp.State = append(resp.State, "stats_window")
+	}
+	hub.markStatsChanged()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// clearDedup deletes this node's dedup marks.
+func (h *Hub) clearDedup(ctx context.Context) error {
+	if h.dedupTTL <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, historyTimeout)
+	defer cancel()
+
+	const batch = 500
+	var keys []string
+	iter := h.redis.Scan(ctx, 0, dedupPattern(h.nodeID), batch).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == batch {
+			if err := h.redis.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return h.redis.Del(ctx, keys...).Err()
+	}
+	return nil
+}
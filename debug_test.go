@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recordResetRun records the same few orders a load test run would: a
+// high-value order and its redelivery, a failed order and a stale one.
+func recordResetRun(hub *Hub) {
+	hub.recordOrder(testOrder("1", StatusCompleted, 500), testNow, 100*time.Millisecond)
+	hub.recordOrder(testOrder("1", StatusCompleted, 500), testNow, 100*time.Millisecond)
+	hub.recordOrder(testOrder("2", StatusFailed, 20), testNow, 100*time.Millisecond)
+	stale := testOrder("3", StatusCompleted, 10)
+	stale.Timestamp = testNow.Add(-2 * time.Hour)
+	hub.recordOrder(stale, testNow, 0)
+}
+
+// assertMetricsZero fails for every sample of the resettable metrics that
+// is not zero.
+func assertMetricsZero(t *testing.T) {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	for _, m := range resettableMetrics() {
+		reg.MustRegister(m.metric.(prometheus.Collector))
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if v := m.GetCounter().GetValue() + float64(m.GetHistogram().GetSampleCount()); v != 0 {
+				t.Errorf("%s%v = %v after reset, want 0", family.GetName(), m.GetLabel(), v)
+			}
+		}
+	}
+}
+
+func TestDebugReset(t *testing.T) {
+	hub, mr := newTestHub(t, "--node-id", "node-a", "--high-value-threshold", "100", "--max-order-age", "1h")
+	hub.history.ping()
+	mr.Set(dedupKeyPrefix+"node-b:1:completed", "1")
+	completed := ordersTotal.WithLabelValues(string(StatusCompleted), "node-a", "orders")
+
+	recordResetRun(hub)
+	if got := testutil.ToFloat64(ordersDeduplicated); got == 0 {
+		t.Fatal("redelivered order not deduplicated before the reset")
+	}
+
+	rec := httptest.NewRecorder()
+	handleDebugReset(hub, rec, httptest.NewRequest(http.MethodPost, "/debug/reset", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	assertMetricsZero(t)
+	if stats := hub.generateStats(); stats.TotalOrders != 0 || stats.TotalRevenue != 0 {
+		t.Errorf("stats after reset = %d orders, %v revenue; want none", stats.TotalOrders, stats.TotalRevenue)
+	}
+	if !mr.Exists(dedupKeyPrefix + "node-b:1:completed") {
+		t.Error("reset cleared another node's dedup mark")
+	}
+
+	// The same IDs count again from zero, as in the first run
+	recordResetRun(hub)
+	for _, tt := range []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"orders_total{status=completed}", testutil.ToFloat64(completed), 1},
+		{"orders_deduplicated_total", testutil.ToFloat64(ordersDeduplicated), 1},
+		{"orders_too_old_total", testutil.ToFloat64(ordersTooOld), 1},
+		{"high_value_orders_total", testutil.ToFloat64(highValueOrders), 1},
+		{"order_amount count", float64(histogramCount(t, orderAmount.histogram())), 2},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v after the second run, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if got := hub.generateStats().LifetimeOrders; got != 2 {
+		t.Errorf("recorded %d orders in the second run, want 2", got)
+	}
+}
+
+func TestDebugResetStoreDown(t *testing.T) {
+	hub, mr := newTestHub(t)
+	hub.recordOrder(testOrder("1", StatusCompleted, 10), testNow, 0)
+	mr.Close()
+
+	rec := httptest.NewRecorder()
+	handleDebugReset(hub, rec, httptest.NewRequest(http.MethodPost, "/debug/reset", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 while the dedup marks can't be cleared", rec.Code)
+	}
+	if got := hub.generateStats().LifetimeOrders; got != 1 {
+		t.Errorf("failed reset left %d orders, want the 1 untouched", got)
+	}
+}
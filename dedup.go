@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// dedupKeyPrefix prefixes the Redis keys marking orders as already recorded.
+// Every instance records every order, so the keys are per node.
+const dedupKeyPrefix = "dedup:"
+
+// dedupTimeout bounds the dedup check, which sits on the recording path.
+const dedupTimeout = 500 * time.Millisecond
+
+// duplicate reports whether the order was already recorded by this node in
+// the same status within the dedup TTL, marking it as recorded otherwise. A
+// status change is news, not a retry, so it is keyed in. Orders without an
+// ID can't be deduplicated. While the store is down every order is recorded:
+// double counting a retry beats losing orders or stalling the workers.
+func (h *Hub) duplicate(order Order) bool {
+	if h.dedupTTL <= 0 || order.ID == "" || !h.history.healthy.Load() {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dedupTimeout)
+	defer cancel()
+
+	fresh, err := h.redis.SetNX(ctx, dedupKeyPrefix+h.nodeID+":"+order.ID+":"+string(order.Status), 1, h.dedupTTL).Result()
+	if err != nil {
+		log.Printf("Order dedup check failed for %s, recording it: %v", order.ID, err)
+		return false
+	}
+	if !fresh {
+		ordersDeduplicated.Inc()
+	}
+	return !fresh
+}
+
+// globEscaper escapes the characters SCAN patterns treat specially
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// dedupPattern matches every dedup mark of the node.
+func dedupPattern(nodeID string) string {
+	return globEscaper.Replace(dedupKeyPrefix+nodeID+":") + "*"
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// eventShutdown warns clients that the server is about to close their
+// connection for a restart.
+const eventShutdown = "shutdown"
+
+// ShutdownNotice is the payload of the shutdown envelope
+type ShutdownNotice struct {
+	Reason string `json:"reason"`
+}
+
+// drain disconnects every client on shutdown: each is sent a shutdown
+// envelope after whatever it has queued, then a service-restart close frame,
+// and is given the drain grace period to complete the close handshake before
+// its connection is closed regardless. Only called by run().
+func (h *Hub) drain() {
+	notice, err := newEnvelope(eventShutdown, ShutdownNotice{Reason: "server restarting"})
+	if err != nil {
+		log.Printf("Shutdown notice encode error: %v", err)
+	}
+	closing := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+
+	clients := h.SnapshotConnections()
+	for _, c := range clients {
+		if notice != nil {
+			select {
+			case c.send <- notice:
+			default:
+			}
+		}
+		c.closing = closing
+		c.closed = true
+		close(c.send)
+	}
+	if len(clients) > 0 {
+		log.Printf("Draining %d WebSocket clients for up to %s", len(clients), h.drainGrace)
+	}
+
+	grace := time.NewTimer(h.drainGrace)
+	defer grace.Stop()
+	for h.ConnectionCount() > 0 {
+		select {
+		case c := <-h.unregister:
+			c.conn.Close()
+			h.mu.RLock()
+			_, ok := h.clients[c]
+			h.mu.RUnlock()
+			if ok {
+				h.forget(c)
+			}
+		case c := <-h.register:
+			// Upgraded after shutdown began
+			close(c.send)
+			c.conn.Close()
+		case <-grace.C:
+			remaining := h.SnapshotConnections()
+			log.Printf("Closing %d WebSocket clients that did not disconnect within %s", len(remaining), h.drainGrace)
+			for _, c := range remaining {
+				c.conn.Close()
+				h.forget(c)
+			}
+		}
+	}
+}
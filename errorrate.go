@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// errorRateWindow is how far back the error rate looks.
+const errorRateWindow = 5 * time.Minute
+
+type outcomeBucket struct {
+	second int64
+	total  int
+	failed int
+}
+
+// outcomeWindow counts orders and failures over a sliding window in
+// one-second buckets, so the error rate reflects recent traffic rather than
+// everything since start
+type outcomeWindow struct {
+	mu      sync.Mutex
+	buckets []outcomeBucket
+}
+
+func newOutcomeWindow(window time.Duration) *outcomeWindow {
+	return &outcomeWindow{buckets: make([]outcomeBucket, int(window/time.Second))}
+}
+
+func (w *outcomeWindow) add(at time.Time, failed bool) {
+	second := at.Unix()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := &w.buckets[int(second%int64(len(w.buckets)))]
+	if b.second != second {
+		*b = outcomeBucket{second: second}
+	}
+	b.total++
+	if failed {
+		b.failed++
+	}
+}
+
+// reset empties the window.
+func (w *outcomeWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	clear(w.buckets)
+}
+
+// rate returns the failed share of orders in the window ending at now, zero
+// when there were none.
+func (w *outcomeWindow) rate(now time.Time) float64 {
+	oldest := now.Unix() - int64(len(w.buckets))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var total, failed int
+	for _, b := range w.buckets {
+		if b.second > oldest && b.second <= now.Unix() {
+			total += b.total
+			failed += b.failed
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(failed) / float64(total)
+}
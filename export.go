@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportFlushRows is how many CSV rows are written between flushes.
+const exportFlushRows = 500
+
+// orderCSVHeader is the header row of CSV order exports
+var orderCSVHeader = []string{"id", "customer", "amount", "status", "timestamp"}
+
+func orderCSVRecord(order Order) []string {
+	return []string{
+		order.ID,
+		order.Customer,
+		strconv.FormatFloat(order.Amount, 'f', 2, 64),
+		string(order.Status),
+		order.Timestamp.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// streamOrdersCSV writes the orders as CSV, flushing periodically so large
+// exports reach the client incrementally. It stops early when the request is
+// cancelled.
+func streamOrdersCSV(w http.ResponseWriter, r *http.Request, orders []Order) {
+	flusher, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+	cw.Write(orderCSVHeader)
+
+	ctx := r.Context()
+	for i, order := range orders {
+		if ctx.Err() != nil {
+			log.Printf("Order export aborted after %d rows: %v", i, ctx.Err())
+			return
+		}
+		if err := cw.Write(orderCSVRecord(order)); err != nil {
+			log.Printf("Order export write error: %v", err)
+			return
+		}
+		if (i+1)%exportFlushRows == 0 {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	cw.Flush()
+}
+
+func handleOrdersExport(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	orders := hub.orders.snapshot()
+	truncated := len(orders) > hub.exportMaxRows
+	if truncated {
+		// Keep the most recent rows
+		orders = orders[len(orders)-hub.exportMaxRows:]
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="orders.csv"`)
+	w.Header().Set("X-Export-Truncated", strconv.FormatBool(truncated))
+	streamOrdersCSV(w, r, orders)
+}
+
+// handleRecentOrdersCSV serves the persisted order history as CSV, newest
+// first. ?status= keeps the listed statuses and ?limit= caps the rows, which
+// count after filtering.
+func handleRecentOrdersCSV(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit, ok := parseLimit(r, historyLength, historyLength)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	filter, err := queryStatusFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	orders, err := hub.history.recent(r.Context(), historyLength)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if filter != nil {
+		// Filter in place; the slice is ours
+		kept := orders[:0]
+		for _, order := range orders {
+			if filter[order.Status] {
+				kept = append(kept, order)
+			}
+		}
+		orders = kept
+	}
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="recent-orders.csv"`)
+	streamOrdersCSV(w, r, orders)
+}
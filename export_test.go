@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// cancellingRecorder cancels the request on the first flush, like a client
+// aborting the download once the first rows arrive.
+type cancellingRecorder struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (r *cancellingRecorder) Flush() {
+	r.ResponseRecorder.Flush()
+	r.cancel()
+}
+
+func fillOrderBuffer(hub *Hub, n int) {
+	for i := 1; i <= n; i++ {
+		hub.orders.add(testOrder(fmt.Sprint(i), StatusCompleted, float64(i)))
+	}
+}
+
+func readCSV(t *testing.T, rec *httptest.ResponseRecorder) [][]string {
+	t.Helper()
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("decoding CSV: %v", err)
+	}
+	return records
+}
+
+func TestOrdersExportStopsWhenCancelled(t *testing.T) {
+	hub, _ := newTestHub(t)
+	fillOrderBuffer(hub, 10000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := &cancellingRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/export", nil).WithContext(ctx)
+
+	returned := make(chan struct{})
+	go func() {
+		handleOrdersExport(hub, rec, req)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("export still running a second after the request was cancelled")
+	}
+
+	// The header and the rows up to the first flush
+	if rows := len(readCSV(t, rec.ResponseRecorder)); rows != exportFlushRows+1 {
+		t.Errorf("exported %d lines after cancelling at the first flush, want %d", rows, exportFlushRows+1)
+	}
+}
+
+func TestOrdersExportTruncates(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxRows       string
+		wantTruncated string
+		wantIDs       []string
+	}{
+		{name: "under the cap", maxRows: "10", wantTruncated: "false", wantIDs: []string{"1", "2", "3", "4", "5"}},
+		{name: "over the cap", maxRows: "3", wantTruncated: "true", wantIDs: []string{"3", "4", "5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, _ := newTestHub(t, "--export-max-rows", tt.maxRows)
+			fillOrderBuffer(hub, 5)
+
+			rec := httptest.NewRecorder()
+			handleOrdersExport(hub, rec, httptest.NewRequest(http.MethodGet, "/api/orders/export", nil))
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv" {
+				t.Fatalf("status %d, Content-Type %q; want 200 text/csv", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			if got := rec.Header().Get("X-Export-Truncated"); got != tt.wantTruncated {
+				t.Errorf("X-Export-Truncated = %q, want %q", got, tt.wantTruncated)
+			}
+
+			records := readCSV(t, rec)
+			if len(records) != len(tt.wantIDs)+1 {
+				t.Fatalf("got %d lines, want a header and %d rows", len(records), len(tt.wantIDs))
+			}
+			if fmt.Sprint(records[0]) != fmt.Sprint(orderCSVHeader) {
+				t.Errorf("header = %v, want %v", records[0], orderCSVHeader)
+			}
+			for i, id := range tt.wantIDs {
+				if records[i+1][0] != id {
+					t.Errorf("row %d is order %s, want %s", i+1, records[i+1][0], id)
+				}
+			}
+		})
+	}
+}
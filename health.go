@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readyTimeout bounds the Redis ping behind /readyz.
+const readyTimeout = time.Second
+
+// selfTestTimeout bounds the startup pub/sub round trip.
+const selfTestTimeout = 5 * time.Second
+
+// selfTestChannelPrefix prefixes the node's private self-test channel
+const selfTestChannelPrefix = "monitor:selftest:"
+
+// Health is the response of /healthz and /readyz
+type Health struct {
+	Status string `json:"status"`
+	Redis  string `json:"redis,omitempty"`
+}
+
+// pingRedis checks that Redis answers.
+func (h *Hub) pingRedis(ctx context.Context) error {
+	return h.redis.Ping(ctx).Err()
+}
+
+// selfTest publishes a sentinel on a private channel and waits for it to come
+// back through a subscription, proving publish and subscribe both work
+// against the configured Redis before clients connect.
+func (h *Hub) selfTest(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	channel := selfTestChannelPrefix + h.nodeID
+	pubsub := h.redis.Subscribe(ctx, channel)
+	defer pubsub.Close()
+	// Wait for the subscription to be confirmed, or the sentinel may be
+	// published before anyone listens
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("subscribing to %s: %w", channel, err)
+	}
+
+	sentinel := newOrderID()
+	if err := h.redis.Publish(ctx, channel, sentinel).Err(); err != nil {
+		return fmt.Errorf("publishing to %s: %w", channel, err)
+	}
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("waiting for the sentinel on %s: %w", channel, err)
+		}
+		if msg.Payload == sentinel {
+			return nil
+		}
+	}
+}
+
+// handleHealthz is the liveness probe: the process is up and serving.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Health{Status: "ok"})
+}
+
+// handleReadyz is the readiness probe: Redis must be reachable.
+func handleReadyz(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	if err := hub.pingRedis(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, Health{Status: "unavailable", Redis: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, Health{Status: "ok", Redis: "ok"})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HighValueOrderEvent is broadcast when an order's amount exceeds the
+// configured high-value threshold
+type HighValueOrderEvent struct {
+	Seq       uint64  `json:"seq"`
+	Order     Order   `json:"order"`
+	Threshold float64 `json:"threshold"`
+}
+
+// highValueLimiter caps high-value events per clock minute so a burst of
+// large orders doesn't flood the feed. A zero cap is unlimited.
+type highValueLimiter struct {
+	mu     sync.Mutex
+	max    int
+	minute time.Time
+	sent   int
+}
+
+func (l *highValueLimiter) allow(now time.Time) bool {
+	if l.max <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if minute := now.Truncate(time.Minute); !minute.Equal(l.minute) {
+		l.minute = minute
+		l.sent = 0
+	}
+	if l.sent >= l.max {
+		return false
+	}
+	l.sent++
+	return true
+}
+
+// notifyHighValue counts and broadcasts orders above the high-value
+// threshold. A zero threshold disables the feed.
+func (h *Hub) notifyHighValue(order Order) {
+	if h.highValueThreshold <= 0 || order.Amount <= h.highValueThreshold {
+		return
+	}
+	highValueOrders.Inc()
+	if !h.highValueLimit.allow(h.now()) {
+		highValueEventsSuppressed.Inc()
+		return
+	}
+
+	seq := h.orderSeq.Add(1)
+	event, err := newEnvelope(eventHighValueOrder, HighValueOrderEvent{
+		Seq:       seq,
+		Order:     order,
+		Threshold: h.highValueThreshold,
+	})
+	if err != nil {
+		log.Printf("High-value event encode error: %v", err)
+		return
+	}
+	h.publish(message{data: event, seq: seq, status: order.Status, region: order.Region})
+}
+
+// HighValueOrders is the response of /api/orders/high-value
+type HighValueOrders struct {
+	Window    string  `json:"window"`
+	Threshold float64 `json:"threshold"`
+	Orders    []Order `json:"orders"`
+}
+
+// topHighValue returns the k largest orders above threshold, largest first.
+func topHighValue(orders []Order, threshold float64, k int) []Order {
+	top := []Order{}
+	for _, order := range orders {
+		if order.Amount > threshold {
+			top = append(top, order)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].Amount > top[j].Amount
+	})
+	if len(top) > k {
+		top = top[:k]
+	}
+	return top
+}
+
+// handleHighValueOrders serves the largest high-value orders in the window,
+// including those whose live event was suppressed by the rate cap.
+func handleHighValueOrders(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if hub.highValueThreshold <= 0 {
+		writeError(w, http.StatusNotFound, "high-value feed is disabled")
+		return
+	}
+	window, ok := parseWindow(r, time.Hour)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "window must be a positive duration")
+		return
+	}
+
+	end := hub.now()
+	writeJSON(w, http.StatusOK, HighValueOrders{
+		Window:    window.String(),
+		Threshold: hub.highValueThreshold,
+		Orders:    topHighValue(hub.orders.between(end.Add(-window), end), hub.highValueThreshold, hub.highValueTopK),
+	})
+}
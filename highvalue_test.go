@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// readUntilStats collects the envelopes a client receives up to the next
+// stats message.
+func readUntilStats(t *testing.T, conn *websocket.Conn) []Envelope {
+	t.Helper()
+
+	var envelopes []Envelope
+	for {
+		env := readEnvelope(t, conn)
+		if env.Type == eventStats {
+			return envelopes
+		}
+		envelopes = append(envelopes, env)
+	}
+}
+
+// publishStatsMarker broadcasts a stats message, for readUntilStats to stop at.
+func publishStatsMarker(t *testing.T, hub *Hub) {
+	t.Helper()
+
+	data, err := hub.statsMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.publish(message{data: data})
+}
+
+func TestHighValueOrderEvents(t *testing.T) {
+	hub, _ := newTestHub(t, "--high-value-threshold", "100")
+	base := serveTestHub(t, hub)
+	stats := dialTestHub(t, base+"/ws")
+	orders := dialTestHub(t, base+"/ws/orders")
+	readEnvelope(t, stats) // initial snapshot
+	waitFor(t, "both clients to register", func() bool { return hub.ConnectionCount() == 2 })
+	before := testutil.ToFloat64(highValueOrders)
+
+	hub.recordOrder(testOrder("small", StatusCompleted, 50), testNow, 0)
+	hub.recordOrder(testOrder("large", StatusCompleted, 150), testNow, 0)
+	hub.recordOrder(testOrder("at-threshold", StatusCompleted, 100), testNow, 0)
+	publishStatsMarker(t, hub)
+
+	events := readUntilStats(t, stats)
+	if len(events) != 1 || events[0].Type != eventHighValueOrder {
+		t.Fatalf("/ws got %+v, want a single high_value_order event", events)
+	}
+	var event HighValueOrderEvent
+	if err := json.Unmarshal(events[0].Data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Order.ID != "large" || event.Threshold != 100 || event.Seq == 0 {
+		t.Errorf("event = %+v, want order large above 100 with a sequence number", event)
+	}
+	if got := testutil.ToFloat64(highValueOrders) - before; got != 1 {
+		t.Errorf("high_value_orders_total rose by %v, want 1", got)
+	}
+
+	// Regular order events still carry every order
+	for _, id := range []string{"small", "large", "at-threshold"} {
+		env := readEnvelope(t, orders)
+		var order Order
+		if err := json.Unmarshal(env.Data, &order); err != nil {
+			t.Fatal(err)
+		}
+		if env.Type != eventOrder || order.ID != id {
+			t.Errorf("/ws/orders got %s %s, want order %s", env.Type, order.ID, id)
+		}
+	}
+}
+
+func TestHighValueDisabled(t *testing.T) {
+	hub, _ := newTestHub(t)
+	before := testutil.ToFloat64(highValueOrders)
+
+	hub.recordOrder(testOrder("1", StatusCompleted, 1e9), testNow, 0)
+	if got := testutil.ToFloat64(highValueOrders) - before; got != 0 {
+		t.Errorf("high_value_orders_total rose by %v with the feed disabled", got)
+	}
+	select {
+	case msg := <-hub.broadcast:
+		t.Errorf("broadcast %s with the feed disabled", msg.data)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// drainHighValueEvents returns the high-value events waiting to be broadcast.
+func drainHighValueEvents(t *testing.T, hub *Hub) []HighValueOrderEvent {
+	t.Helper()
+
+	var events []HighValueOrderEvent
+	for {
+		select {
+		case msg := <-hub.broadcast:
+			var env Envelope
+			if err := json.Unmarshal(msg.data, &env); err != nil {
+				t.Fatal(err)
+			}
+			if env.Type != eventHighValueOrder {
+				continue
+			}
+			var event HighValueOrderEvent
+			if err := json.Unmarshal(env.Data, &event); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestHighValueRateCap(t *testing.T) {
+	hub, _ := newTestHub(t, "--high-value-threshold", "100", "--high-value-max-per-minute", "3")
+	now := testNow
+	hub.now = func() time.Time { return now }
+	counted := testutil.ToFloat64(highValueOrders)
+	suppressed := testutil.ToFloat64(highValueEventsSuppressed)
+
+	for i := 1; i <= 6; i++ {
+		hub.recordOrder(testOrder(fmt.Sprint(i), StatusCompleted, float64(100+i*100)), now, 0)
+	}
+	events := drainHighValueEvents(t, hub)
+	if len(events) != 3 || events[0].Order.ID != "1" || events[2].Order.ID != "3" {
+		t.Errorf("broadcast %+v, want the first 3 of the burst", events)
+	}
+	if got := testutil.ToFloat64(highValueOrders) - counted; got != 6 {
+		t.Errorf("high_value_orders_total rose by %v, want every one of the 6", got)
+	}
+	if got := testutil.ToFloat64(highValueEventsSuppressed) - suppressed; got != 3 {
+		t.Errorf("high_value_events_suppressed_total rose by %v, want 3", got)
+	}
+
+	// The cap is per clock minute
+	now = testNow.Add(time.Minute)
+	hub.recordOrder(testOrder("7", StatusCompleted, 150), now, 0)
+	if events := drainHighValueEvents(t, hub); len(events) != 1 || events[0].Order.ID != "7" {
+		t.Errorf("next minute broadcast %+v, want order 7", events)
+	}
+}
+
+func TestHighValueTopK(t *testing.T) {
+	hub, _ := newTestHub(t, "--high-value-threshold", "100", "--high-value-max-per-minute", "1", "--high-value-top-k", "2")
+	for i, amount := range []float64{300, 50, 700, 500, 100} {
+		order := testOrder(fmt.Sprint(i+1), StatusCompleted, amount)
+		order.Timestamp = testNow.Add(-time.Duration(i+1) * time.Minute)
+		hub.recordOrder(order, order.Timestamp, 0)
+	}
+	old := testOrder("old", StatusCompleted, 10000)
+	old.Timestamp = testNow.Add(-2 * time.Hour)
+	hub.recordOrder(old, old.Timestamp, 0)
+
+	rec := httptest.NewRecorder()
+	handleHighValueOrders(hub, rec, httptest.NewRequest(http.MethodGet, "/api/orders/high-value", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var top HighValueOrders
+	decodeJSON(t, rec, &top)
+	// Orders whose live event was suppressed are retained too
+	if len(top.Orders) != 2 || top.Orders[0].Amount != 700 || top.Orders[1].Amount != 500 {
+		t.Errorf("top orders = %+v, want 700 and 500", top.Orders)
+	}
+	if top.Window != "1h0m0s" || top.Threshold != 100 {
+		t.Errorf("window %s, threshold %v; want 1h and 100", top.Window, top.Threshold)
+	}
+
+	rec = httptest.NewRecorder()
+	handleHighValueOrders(hub, rec, httptest.NewRequest(http.MethodGet, "/api/orders/high-value?window=3h", nil))
+	decodeJSON(t, rec, &top)
+	if len(top.Orders) != 2 || top.Orders[0].ID != "old" {
+		t.Errorf("over 3h top orders = %+v, want the old one first", top.Orders)
+	}
+}
+
+func TestHighValueOrdersDisabled(t *testing.T) {
+	hub, _ := newTestHub(t)
+	rec := httptest.NewRecorder()
+	handleHighValueOrders(hub, rec, httptest.NewRequest(http.MethodGet, "/api/orders/high-value", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 with the feed disabled", rec.Code)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// historyKey is the Redis list recent orders are kept in, newest first.
+const historyKey = "orders:recent"
+
+// historyLength bounds the Redis order history.
+const historyLength = 1000
+
+// historyTimeout bounds a single store round trip.
+const historyTimeout = 2 * time.Second
+
+// historyRecheckInterval is how often the store is pinged when no write has
+// vouched for its health, so the health stays current while no orders are
+// being written.
+const historyRecheckInterval = 5 * time.Second
+
+// orderKeyPrefix prefixes the per-order keys GET /orders/{id} reads.
+const orderKeyPrefix = "order:"
+
+var (
+	errStoreUnavailable = errors.New("order history store is unavailable")
+	errOrderNotFound    = errors.New("order not found")
+)
+
+// historyStore persists recent orders to Redis. Writes are queued and
+// retried in the background, so a store outage never blocks ingestion or
+// live stats; when the queue is full the oldest pending write is dropped.
+type historyStore struct {
+	redis    redis.UniversalClient
+	pending  chan Order
+	healthy  atomic.Bool
+	orderTTL time.Duration // how long an order stays retrievable by ID
+	stop     chan struct{} // closed by shutdown
+	done     chan struct{} // closed when run returns
+}
+
+func newHistoryStore(rdb redis.UniversalClient, queueSize int, orderTTL time.Duration) *historyStore {
+	return &historyStore{
+		redis:    rdb,
+		pending:  make(chan Order, queueSize),
+		orderTTL: orderTTL,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// record queues an order for persistence without blocking.
+func (s *historyStore) record(order Order) {
+	for {
+		select {
+		case s.pending <- order:
+			return
+		default:
+		}
+		select {
+		case <-s.pending:
+			historyWritesDropped.Inc()
+		default:
+		}
+	}
+}
+
+// run writes queued orders, retrying each with backoff until the store
+// accepts it or shutdown is called. Every historyRecheckInterval the store
+// is also pinged if it is unhealthy or nothing was written since the last
+// check, since otherwise only a write could notice Redis recovering or going
+// away.
+func (s *historyStore) run() {
+	defer close(s.done)
+
+	s.ping()
+	ticker := time.NewTicker(historyRecheckInterval)
+	defer ticker.Stop()
+
+	wrote := false
+	for {
+		select {
+		case order := <-s.pending:
+			s.persist(order)
+			wrote = true
+		case <-ticker.C:
+			if !wrote || !s.healthy.Load() {
+				s.ping()
+			}
+			wrote = false
+		case <-s.stop:
+			s.drain()
+			return
+		}
+	}
+}
+
+// drain writes the orders still queued, once each. While the store is down
+// they are dropped and counted, rather than holding up shutdown.
+func (s *historyStore) drain() {
+	for {
+		select {
+		case order := <-s.pending:
+			if !s.healthy.Load() {
+				historyWritesDropped.Inc()
+				continue
+			}
+			err := s.write(order)
+			s.setHealthy(err == nil)
+			if err != nil {
+				log.Printf("Order history write failed at shutdown, dropping order %s: %v", order.ID, err)
+				historyWritesDropped.Inc()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// shutdown stops run once the queue is drained, or when ctx expires. The
+// order being retried, if any, is dropped.
+func (s *historyStore) shutdown(ctx context.Context) bool {
+	close(s.stop)
+	select {
+	case <-s.done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ping checks that Redis answers and updates the health accordingly.
+func (s *historyStore) ping() {
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+	defer cancel()
+
+	s.setHealthy(s.redis.Ping(ctx).Err() == nil)
+}
+
+// persist writes one order, retrying with backoff until the store accepts
+// it. On shutdown the order is dropped and counted instead.
+func (s *historyStore) persist(order Order) {
+	backoff := time.Second
+	for {
+		err := s.write(order)
+		s.setHealthy(err == nil)
+		if err == nil {
+			return
+		}
+		log.Printf("Order history write failed, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+			log.Printf("Dropping order %s from the history at shutdown", order.ID)
+			historyWritesDropped.Inc()
+			return
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (s *historyStore) write(order Order) error {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+	defer cancel()
+
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, historyKey, data)
+		pipe.LTrim(ctx, historyKey, 0, historyLength-1)
+		pipe.Set(ctx, orderKeyPrefix+order.ID, data, s.orderTTL)
+		return nil
+	})
+	return err
+}
+
+func (s *historyStore) setHealthy(healthy bool) {
+	if s.healthy.Swap(healthy) != healthy {
+		log.Printf("Order history store healthy: %t", healthy)
+	}
+	if healthy {
+		storeHealthy.Set(1)
+	} else {
+		storeHealthy.Set(0)
+	}
+}
+
+// recent returns up to limit persisted orders, newest first.
+func (s *historyStore) recent(ctx context.Context, limit int) ([]Order, error) {
+	if !s.healthy.Load() {
+		return nil, errStoreUnavailable
+	}
+	ctx, cancel := context.WithTimeout(ctx, historyTimeout)
+	defer cancel()
+
+	values, err := s.redis.LRange(ctx, historyKey, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	orders := make([]Order, 0, len(values))
+	for _, value := range values {
+		// Passthrough statuses are stored as received
+		order, err := decodeOrderAnyStatus([]byte(value))
+		if err != nil {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// order returns the persisted order with the given ID.
+func (s *historyStore) order(ctx context.Context, id string) (Order, error) {
+	if !s.healthy.Load() {
+		return Order{}, errStoreUnavailable
+	}
+	ctx, cancel := context.WithTimeout(ctx, historyTimeout)
+	defer cancel()
+
+	value, err := s.redis.Get(ctx, orderKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return Order{}, errOrderNotFound
+	}
+	if err != nil {
+		return Order{}, err
+	}
+	return decodeOrderAnyStatus(value)
+}
+
+// handleGetOrder serves GET /orders/{id}: one persisted order, for as long
+// as --order-ttl keeps it.
+func handleGetOrder(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/orders/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	order, err := hub.history.order(r.Context(), id)
+	switch {
+	case err == errOrderNotFound:
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeNegotiated(w, r, http.StatusOK, order)
+	}
+}
+
+// handleRecentOrders serves the persisted order history. Unlike /api/orders
+// it survives restarts, and it answers 503 while the store is down.
+func handleRecentOrders(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if orders, ok := recentOrders(hub, w, r); ok {
+		writeNegotiated(w, r, http.StatusOK, OrderList{Count: len(orders), Orders: orders})
+	}
+}
+
+// handleRecentOrdersArray serves the same history as a bare JSON array, for
+// the dashboard to backfill from on page load.
+func handleRecentOrdersArray(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if orders, ok := recentOrders(hub, w, r); ok {
+		writeJSON(w, http.StatusOK, orders)
+	}
+}
+
+// recentOrders reads the history for a request, writing the error response
+// and reporting false on failure.
+func recentOrders(hub *Hub, w http.ResponseWriter, r *http.Request) ([]Order, bool) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return nil, false
+	}
+	limit, ok := parseLimit(r, defaultOrderListLimit, historyLength)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return nil, false
+	}
+
+	orders, err := hub.history.recent(r.Context(), limit)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return nil, false
+	}
+	return orders, true
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// persistPending writes every queued order, as the history store's run loop
+// would.
+func persistPending(hub *Hub) {
+	for {
+		select {
+		case order := <-hub.history.pending:
+			hub.history.persist(order)
+		default:
+			return
+		}
+	}
+}
+
+func TestHistoryPersistsOrders(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.history.ping()
+	if got := testutil.ToFloat64(storeHealthy); got != 1 {
+		t.Errorf("store_healthy = %v, want 1", got)
+	}
+
+	hub.recordOrder(testOrder("1", StatusCompleted, 10), testNow, 0)
+	hub.recordOrder(testOrder("2", StatusPending, 20), testNow, 0)
+	persistPending(hub)
+
+	rec := httptest.NewRecorder()
+	handleRecentOrders(hub, rec, httptest.NewRequest(http.MethodGet, "/api/orders/recent", nil))
+	var list OrderList
+	decodeJSON(t, rec, &list)
+	if list.Count != 2 || list.Orders[0].ID != "2" || list.Orders[1].ID != "1" {
+		t.Errorf("recent orders = %+v, want 2 then 1", list.Orders)
+	}
+
+	rec = httptest.NewRecorder()
+	handleGetOrder(hub, rec, httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+	var order Order
+	decodeJSON(t, rec, &order)
+	if rec.Code != http.StatusOK || order.ID != "1" || order.Amount != 10 {
+		t.Errorf("GET /orders/1 = %d %+v, want order 1", rec.Code, order)
+	}
+
+	rec = httptest.NewRecorder()
+	handleGetOrder(hub, rec, httptest.NewRequest(http.MethodGet, "/orders/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /orders/missing = %d, want 404", rec.Code)
+	}
+}
+
+func TestStoreDownDegradesGracefully(t *testing.T) {
+	hub, mr := newTestHub(t)
+	hub.history.ping()
+	mr.Close()
+	hub.history.ping()
+	if hub.history.healthy.Load() {
+		t.Fatal("store still healthy with Redis down")
+	}
+	if got := testutil.ToFloat64(storeHealthy); got != 0 {
+		t.Errorf("store_healthy = %v, want 0", got)
+	}
+
+	// Live stats are unaffected
+	hub.recordOrder(testOrder("1", StatusCompleted, 10), testNow, 0)
+	hub.recordOrder(testOrder("2", StatusFailed, 20), testNow, 0)
+	if got := hub.generateStats().TotalOrders; got != 2 {
+		t.Errorf("total_orders = %d, want 2", got)
+	}
+	rec := httptest.NewRecorder()
+	handleOrders(hub, rec, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+	var list OrderList
+	decodeJSON(t, rec, &list)
+	if rec.Code != http.StatusOK || list.Count != 2 {
+		t.Errorf("/api/orders = %d with %d orders, want 200 with 2", rec.Code, list.Count)
+	}
+	rec = httptest.NewRecorder()
+	handleStats(hub, rec, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/api/stats = %d, want 200", rec.Code)
+	}
+
+	// Only the store endpoints answer 503
+	for _, tt := range []struct {
+		path    string
+		handler func(*Hub, http.ResponseWriter, *http.Request)
+	}{
+		{"/api/orders/recent", handleRecentOrders},
+		{"/orders/recent", handleRecentOrdersArray},
+		{"/orders/1", handleGetOrder},
+		{"/orders/deadletter", handleDeadLetters},
+		{"/orders/export.csv", handleRecentOrdersCSV},
+	} {
+		rec := httptest.NewRecorder()
+		tt.handler(hub, rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s = %d with Retry-After %q, want 503 with a Retry-After", tt.path, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestStoreRecovers(t *testing.T) {
+	hub, mr := newTestHub(t)
+	mr.Close()
+	hub.history.ping()
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	hub.history.ping()
+	if !hub.history.healthy.Load() || testutil.ToFloat64(storeHealthy) != 1 {
+		t.Error("store not healthy again after Redis came back")
+	}
+	rec := httptest.NewRecorder()
+	handleRecentOrders(hub, rec, httptest.NewRequest(http.MethodGet, "/api/orders/recent", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/api/orders/recent = %d after recovery, want 200", rec.Code)
+	}
+}
+
+func TestHistoryShutdownDrains(t *testing.T) {
+	hub, _ := newTestHub(t)
+	go hub.history.run()
+	hub.recordOrder(testOrder("1", StatusCompleted, 10), testNow, 0)
+	hub.recordOrder(testOrder("2", StatusCompleted, 10), testNow, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !hub.history.shutdown(ctx) {
+		t.Fatal("history writer did not stop")
+	}
+	orders, err := hub.history.recent(context.Background(), 10)
+	if err != nil || len(orders) != 2 {
+		t.Errorf("persisted %d orders (%v), want both queued before shutdown", len(orders), err)
+	}
+}
+
+func TestHistoryShutdownWhileStoreDown(t *testing.T) {
+	hub, mr := newTestHub(t)
+	mr.Close()
+	before := testutil.ToFloat64(historyWritesDropped)
+	go hub.history.run()
+	for i := 1; i <= 3; i++ {
+		hub.recordOrder(testOrder(fmt.Sprint(i), StatusCompleted, 10), testNow, 0)
+	}
+	// One order is being retried, the others wait behind it
+	waitFor(t, "the writer to pick up an order", func() bool { return len(hub.history.pending) == 2 })
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !hub.history.shutdown(ctx) {
+		t.Fatal("history writer stuck retrying at shutdown")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("shutdown took %s, want it not to wait out the retry backoff", elapsed)
+	}
+	if got := testutil.ToFloat64(historyWritesDropped) - before; got != 3 {
+		t.Errorf("history_writes_dropped_total rose by %v, want all 3 orders", got)
+	}
+}
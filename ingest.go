@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// maxOrderBody bounds the size of a submitted order.
+const maxOrderBody = 1 << 20
+
+// orderSubmission is the body of POST /orders
+type orderSubmission struct {
+	Order
+
+	// ProcessingMs is how long the order took to process, if known
+	ProcessingMs *float64 `json:"processing_ms,omitempty"`
+}
+
+func (s orderSubmission) validate() error {
+	// Unknown statuses fail to decode; this catches a missing one
+	if _, err := ParseStatus(string(s.Status)); err != nil {
+		return err
+	}
+	if s.Amount < 0 {
+		return fmt.Errorf("amount must not be negative")
+	}
+	if s.ProcessingMs != nil && *s.ProcessingMs < 0 {
+		return fmt.Errorf("processing_ms must not be negative")
+	}
+	return nil
+}
+
+// handleSubmitOrder queues an order posted by an external producer for
+// recording and publishes it to the other instances. The status is checked
+// up front; recording is asynchronous, so the age policy applies afterwards.
+func handleSubmitOrder(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var sub orderSubmission
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOrderBody)).Decode(&sub); err != nil {
+		hub.reportProcessingError(errorUnmarshal, "", err)
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if err := sub.validate(); err != nil {
+		hub.reportProcessingError(errorValidation, sub.ID, err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := hub.now()
+	order := sub.Order
+	// Only instances mark orders anonymized, when passing them on over the
+	// sink; a producer can't opt its customer out
+	order.Anonymized = false
+	if order.ID == "" {
+		order.ID = newOrderID()
+	}
+	if order.Timestamp.IsZero() {
+		order.Timestamp = now
+	}
+	if order.Tenant == "" {
+		order.Tenant = hub.channels[0]
+	} else if !hub.knownTenant(order.Tenant) {
+		err := fmt.Errorf("tenant must be one of %v, got %q", hub.channels, order.Tenant)
+		hub.reportProcessingError(errorValidation, order.ID, err)
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var latency time.Duration
+	if sub.ProcessingMs != nil {
+		latency = time.Duration(*sub.ProcessingMs * float64(time.Millisecond))
+	}
+	if !hub.workers.tryEnqueue(ingestItem{order: order, at: now, latency: latency}) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "order queue is full")
+		return
+	}
+
+	// Stamped so our own subscriber skips it
+	order.ProcessedBy = hub.nodeID
+	hub.publishOrder(r.Context(), order)
+	writeJSON(w, http.StatusAccepted, order)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// metricLabels is the vetted set of label names metrics may use, each with
+// what keeps its cardinality bounded. Order IDs, amounts and other per-order
+// fields must never become labels: every distinct value creates a new time
+// series. Add a name here only with a bound to go with it.
+var metricLabels = map[string]string{
+	"status":   "known order statuses plus at most maxPassthroughStatuses others",
+	"region":   "the small fixed set of deployment regions",
+	"node":     "one value per monitor instance",
+	"policy":   "the configured handling policy",
+	"code":     "RFC 6455 close codes, others grouped",
+	"customer": "the topRevenueCustomers highest-revenue customers, others grouped",
+	"tenant":   "the configured Redis channels",
+	"reason":   "the fixed set of processing error reasons",
+}
+
+// labelNames returns the label names for a metric vector, panicking at
+// startup if any of them is not vetted.
+func labelNames(names ...string) []string {
+	for _, name := range names {
+		if _, ok := metricLabels[name]; !ok {
+			panic(fmt.Sprintf("metric label %q is not in the vetted low-cardinality set", name))
+		}
+	}
+	return names
+}
+
+// maxPassthroughStatuses caps how many distinct unknown statuses get their
+// own label value under the passthrough policy.
+const maxPassthroughStatuses = 10
+
+// cappedLabel passes through the first few distinct values it sees and maps
+// the rest to "other"
+type cappedLabel struct {
+	mu    sync.Mutex
+	limit int
+	seen  map[string]bool
+}
+
+func newCappedLabel(limit int) *cappedLabel {
+	return &cappedLabel{limit: limit, seen: make(map[string]bool)}
+}
+
+func (l *cappedLabel) value(v string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen[v] {
+		return v
+	}
+	if len(l.seen) >= l.limit {
+		return "other"
+	}
+	l.seen[v] = true
+	return v
+}
+
+// passthroughStatuses bounds the unknown statuses used as label values
+var passthroughStatuses = newCappedLabel(maxPassthroughStatuses)
+
+// statusLabel is the status label value for an order status.
+func statusLabel(status OrderStatus) string {
+	if _, err := ParseStatus(string(status)); err == nil {
+		return string(status)
+	}
+	return passthroughStatuses.value(string(status))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLabelNamesRejectsUnvetted(t *testing.T) {
+	for _, name := range []string{"order_id", "id", "amount", "customer_email", "Status"} {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Errorf("labelNames(%q) did not panic", name)
+					return
+				}
+				if msg := fmt.Sprint(r); !strings.Contains(msg, name) {
+					t.Errorf("labelNames(%q) panic %q does not name the label", name, msg)
+				}
+			}()
+			labelNames("status", name)
+		}()
+	}
+}
+
+func TestLabelNamesAcceptsVetted(t *testing.T) {
+	got := labelNames("status", "region", "node")
+	if fmt.Sprint(got) != "[status region node]" {
+		t.Errorf("labelNames = %v, want the names unchanged", got)
+	}
+}
+
+// TestMetricVectorsUseVettedLabels fails when a metric vector is declared
+// without going through labelNames, which would bypass the guard.
+func TestMetricVectorsUseVettedLabels(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	vectors := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !strings.HasPrefix(sel.Sel.Name, "New") || !strings.HasSuffix(sel.Sel.Name, "Vec") {
+				return true
+			}
+			vectors++
+			if len(call.Args) < 2 || !isLabelNamesCall(call.Args[len(call.Args)-1]) {
+				t.Errorf("%s: %s labels are not built with labelNames", fset.Position(call.Pos()), sel.Sel.Name)
+			}
+			return true
+		})
+	}
+	if vectors == 0 {
+		t.Fatal("no metric vectors found")
+	}
+}
+
+func isLabelNamesCall(expr ast.Expr) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := call.Fun.(*ast.Ident)
+	return ok && ident.Name == "labelNames"
+}
+
+func TestCappedLabel(t *testing.T) {
+	label := newCappedLabel(2)
+	for _, tt := range []struct{ value, want string }{
+		{"a", "a"},
+		{"b", "b"},
+		{"c", "other"},
+		{"a", "a"},
+		{"d", "other"},
+	} {
+		if got := label.value(tt.value); got != tt.want {
+			t.Errorf("value(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -1,48 +1,255 @@
 package main
 
 import (
-	"encoding/json"
+	"compress/flate"
+	"context"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/go-redis/redis/v8"
-	"context"
+	"golang.org/x/sync/singleflight"
 )
 
 // Order represents an e-commerce order
 type Order struct {
-	ID        string    `json:"id"`
-	Customer  string    `json:"customer"`
-	Amount    float64   `json:"amount"`
-	Status    string    `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
+	ID        string      `json:"id" xml:"id"`
+	Customer  string      `json:"customer" xml:"customer"`
+	Amount    float64     `json:"amount" xml:"amount"`
+	Status    OrderStatus `json:"status" xml:"status"`
+	Timestamp time.Time   `json:"timestamp" xml:"timestamp"`
+
+	// SLASeconds is the processing time the order must complete within;
+	// zero means no SLA
+	SLASeconds float64 `json:"sla_seconds,omitempty" xml:"sla_seconds,omitempty"`
+
+	// ProcessedBy is the node ID of the instance that recorded the order
+	ProcessedBy string `json:"processed_by,omitempty" xml:"processed_by,omitempty"`
+
+	// Stale marks orders accepted despite exceeding the maximum order age
+	Stale bool `json:"stale,omitempty" xml:"stale,omitempty"`
+
+	// Currency is the ISO code Amount is in; empty means the base currency
+	Currency string `json:"currency,omitempty" xml:"currency,omitempty"`
+
+	// Tenant is the Redis channel, and so the storefront, the order came from
+	Tenant string `json:"tenant,omitempty" xml:"tenant,omitempty"`
+
+	// Region routes the order's events to WebSocket clients in that room
+	Region string `json:"region,omitempty" xml:"region,omitempty"`
+
+	// Anonymized marks Customer as already replaced by its salted hash
+	Anonymized bool `json:"anonymized,omitempty" xml:"anonymized,omitempty"`
 }
 
 // Stats represents real-time statistics
+//
+// TotalOrders, TotalRevenue and AverageOrder cover the stats window, named
+// by StatsWindow, and the error rate the last five minutes. Everything else
+// counts every order since start, or the last reset: LifetimeOrders,
+// ActiveOrders, StatusCounts, the revenue per currency and OrdersByTenant.
 type Stats struct {
-	TotalOrders    int     `json:"total_orders"`
-	TotalRevenue   float64 `json:"total_revenue"`
-	ActiveOrders   int     `json:"active_orders"`
-	AverageOrder   float64 `json:"average_order"`
-	ErrorRate      float64 `json:"error_rate"`
-	QueueDepth     int     `json:"queue_depth"`
+	XMLName        xml.Name     `json:"-" xml:"stats"`
+	TotalOrders    int          `json:"total_orders" xml:"total_orders"`
+	TotalRevenue   float64      `json:"total_revenue" xml:"total_revenue"`
+	LifetimeOrders int          `json:"lifetime_orders" xml:"lifetime_orders"`
+	ActiveOrders   int          `json:"active_orders" xml:"active_orders"`
+	StatusCounts   StatusCounts `json:"status_counts" xml:"status_counts"`
+	AverageOrder   float64      `json:"average_order" xml:"average_order"`
+	ErrorRate      float64      `json:"error_rate" xml:"error_rate"`
+	QueueDepth     int          `json:"queue_depth" xml:"queue_depth"`
+	RecentCounts   []int        `json:"recent_counts" xml:"recent_counts>count"`
+
+	// StatsWindow is the window of the windowed totals, empty when they
+	// cover all time too
+	StatsWindow string `json:"stats_window,omitempty" xml:"stats_window,omitempty"`
+
+	// Revenue converted into the base currency, and as recorded per currency
+	BaseCurrency      string            `json:"base_currency" xml:"base_currency"`
+	TotalRevenueBase  float64           `json:"total_revenue_base" xml:"total_revenue_base"`
+	RevenueByCurrency []CurrencyRevenue `json:"revenue_by_currency" xml:"revenue_by_currency>currency"`
+	UnconvertedOrders int               `json:"unconverted_orders" xml:"unconverted_orders"`
+	OrdersByTenant    []TenantOrders    `json:"orders_by_tenant" xml:"orders_by_tenant>tenant"`
+}
+
+// client is a registered WebSocket connection
+type client struct {
+	conn *websocket.Conn
+
+	// compressed is set when permessage-deflate was negotiated
+	compressed bool
+
+	// connectedAt is when the handshake completed
+	connectedAt time.Time
+
+	// filter selects which order events the client receives. Set before
+	// registration, then only touched by run(), which keeps the hub's
+	// per-status index in step with it.
+	filter statusFilter
+
+	// room is the region whose order events the client receives, empty for
+	// the global feed of every order
+	room string
+
+	// stream is the endpoint the client connected to, streamStats for /ws
+	stream string
+
+	// Order event delivery tracking for clients that acknowledge events.
+	// unacked holds the sequence numbers delivered since the client's last
+	// ack, in delivery order, which is mostly but not strictly ascending.
+	// They come from the hub-wide sequence, so a filtered client sees gaps
+	// between them and they can't simply be subtracted.
+	acking  atomic.Bool
+	ackMu   sync.Mutex
+	unacked []uint64
+
+	// send queues payloads for the client's writer. run() drops the client
+	// instead of blocking when it is full.
+	send chan []byte
+
+	// closed is set once run() has removed the client. Only touched by run().
+	closed bool
+
+	// closing is the close frame writeLoop sends once send is closed. Set
+	// by run() before closing send, when draining on shutdown.
+	closing []byte
+}
+
+// message is an outbound WebSocket payload
+type message struct {
+	data []byte
+
+	// seq is the order event sequence number, zero for other payloads
+	seq uint64
+
+	// status is the order status the payload concerns, empty when it isn't
+	// about a single order
+	status OrderStatus
+
+	// region is the order's region, for routing to rooms
+	region string
+
+	// stream is the stream the payload belongs to
+	stream string
+
+	// unpausable is delivered even while broadcasting is paused
+	unpausable bool
 }
 
 // WebSocket connection manager
 type Hub struct {
-	clients    map[*websocket.Conn]bool
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
-	broadcast  chan []byte
-	mu         sync.RWMutex
-	redis      *redis.Client
+	clients        map[*client]bool
+	register       chan *client
+	unregister     chan *client
+	broadcast      chan message
+	done           chan struct{} // closed when run() returns
+	mu             sync.RWMutex  // guards clients, which only run() mutates
+	redis          redis.UniversalClient
+	channels       []string // consumed Redis channels; the first is the default tenant
+	sink           OrderSink
+	publishTimeout time.Duration
+	series         *seriesStore
+	alerts         *alertMonitor
+	sla            *slaTracker
+	tsdb           *tsdbExporter
+	orders         *orderBuffer
+	workers        *orderWorkers
+	aggregate      *orderAggregate
+	window         *totalsWindow // nil when the totals cover all time
+	statsWindow    time.Duration
+	revenueSeries  *totalsWindow // per-second totals for /stats/timeseries
+	outcomes       *outcomeWindow
+	revenue        *revenueLedger
+	customers      *customerRanking
+	anonymizer     *customerAnonymizer // nil keeps customers as sent
+	history        *historyStore
+	latencies      *latencyBuffer
+	now            func() time.Time
+	rand           *rand.Rand // simulator only; seed with --simulate-seed
+	sampleRand     *rand.Rand // latency sampling, shared by the workers
+
+	// orderCounts feeds the RecentCounts sparkline
+	orderCounts *intervalCounter
+
+	// orderSeq numbers order events for client acknowledgement
+	orderSeq      atomic.Uint64
+	ackMaxPending uint64
+
+	clientSendBuffer int
+	maxConnections   int
+	drainGrace       time.Duration
+
+	// lastUpdate is when an order last reached the stats, in Unix nanoseconds
+	lastUpdate     atomic.Int64
+	staleThreshold time.Duration
+
+	dedupTTL time.Duration // 0 disables deduplication
+
+	closeOnce sync.Once
+	closeErr  error
+
+	// orderStreamClients counts the registered /ws/orders clients
+	orderStreamClients atomic.Int64
+
+	// paused stops run() from delivering broadcasts; pauseMu serializes
+	// pausing and resuming
+	paused  atomic.Bool
+	pauseMu sync.Mutex
+
+	simulateOrders    bool
+	simulateMode      string
+	simulateRate      float64
+	statsInterval     time.Duration
+	statsDirty        chan struct{}
+	broadcastInterval time.Duration
+
+	// statsGroup coalesces concurrent stats computations
+	statsGroup singleflight.Group
+
+	nodeID               string
+	unknownStatusPolicy  string
+	unknownStatusDefault OrderStatus
+	maxOrderAge          time.Duration
+	staleOrderPolicy     string
+	highValueThreshold   float64
+	highValueTopK        int
+	highValueLimit       *highValueLimiter
+	slowStatsThreshold   time.Duration
+	latencySampleRate    float64
+	exportMaxRows        int
+	sessionGap           time.Duration
+	environment          string
+	dashboardTitle       string
+
+	// departed holds connections whose unregister overtook their register on
+	// the buffered channels. Only touched by run().
+	departed map[*client]bool
+
+	// refilter carries subscribe commands from the read loops to run()
+	refilter chan filterChange
+
+	// Per-status index of clients, so an order event only visits the
+	// clients that want it. Guarded by mu like clients.
+	unfiltered map[*client]bool
+	byStatus   map[OrderStatus]map[*client]bool
 }
 
 // Prometheus metrics
@@ -52,7 +259,77 @@ var (
 			Name: "orders_total",
 			Help: "Total number of orders processed",
 		},
-		[]string{"status"},
+		labelNames("status", "node", "tenant"),
+	)
+
+	revenueTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "revenue_total",
+			Help: "Amount of all orders that did not fail, by tenant",
+		},
+		labelNames("tenant"),
+	)
+
+	// A histogram rather than a summary, so quantiles aggregate across
+	// instances
+	orderAmount = newResettableHistogram(
+		prometheus.HistogramOpts{
+			Name:    "order_amount",
+			Help:    "Order amounts",
+			Buckets: []float64{10, 25, 50, 100, 250, 500, 1000, 2500, 5000},
+		},
+	)
+
+	// Counted for whichever sink is configured; the Redis sink is the default
+	orderPublishErrors = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "order_publish_errors_total",
+			Help: "Failed or timed out order publishes to the order sink",
+		},
+	)
+
+	ordersDeadLettered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_deadlettered_total",
+			Help: "Unprocessable orders dead-lettered, by reason: unmarshal or validation. While Redis is down they are only counted",
+		},
+		labelNames("reason"),
+	)
+
+	processingErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_processing_errors_total",
+			Help: "Incoming orders that failed to process, by reason: unmarshal, validation or publish",
+		},
+		labelNames("reason"),
+	)
+
+	broadcastPaused = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "broadcast_paused",
+			Help: "Whether broadcasting to WebSocket clients is paused (1) or not (0)",
+		},
+	)
+
+	monitorStatsStale = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "monitor_stats_stale",
+			Help: "Whether no order has been recorded for the stale-stats threshold (1) or not (0)",
+		},
+	)
+
+	broadcastsDropped = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "broadcasts_dropped_total",
+			Help: "Messages dropped because the hub's broadcast queue was full",
+		},
+	)
+
+	hubPanics = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "hub_panics_total",
+			Help: "Panics recovered in the hub, stats and subscriber loops",
+		},
 	)
 
 	websocketConnections = prometheus.NewGauge(
@@ -62,204 +339,1236 @@ var (
 		},
 	)
 
-	orderLatency = prometheus.NewHistogram(
+	// Spans rapid reconnect loops (1s) to all-day dashboards (24h)
+	websocketConnectionDuration = newResettableHistogram(
 		prometheus.HistogramOpts{
-			Name: "order_processing_latency_seconds",
-			Help: "Order processing latency",
+			Name:    "websocket_connection_duration_seconds",
+			Help:    "How long WebSocket connections stayed registered",
+			Buckets: []float64{1, 5, 30, 60, 300, 900, 1800, 3600, 4 * 3600, 12 * 3600, 24 * 3600},
+		},
+	)
+
+	websocketConnectionsRejected = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "websocket_connections_rejected_total",
+			Help: "WebSocket handshakes refused because max-connections was reached",
+		},
+	)
+
+	websocketCloses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "websocket_closes_total",
+			Help: "WebSocket connections closed, by close code",
+		},
+		labelNames("code"),
+	)
+
+	websocketCompressedConnections = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "websocket_compressed_connections",
+			Help: "Number of active WebSocket connections using permessage-deflate",
 		},
 	)
+
+	revenueByCustomer = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "customer_revenue_total",
+			Help: "Revenue from completed orders, for the top customers by revenue and other for the rest",
+		},
+		labelNames("customer"),
+	)
+
+	highValueOrders = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "high_value_orders_total",
+			Help: "Orders whose amount exceeded the high-value threshold",
+		},
+	)
+
+	statsComputationDuration = newResettableHistogram(
+		prometheus.HistogramOpts{
+			Name:    "stats_computation_duration_seconds",
+			Help:    "Time spent computing a stats snapshot",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		},
+	)
+
+	// Writes happen on each client's writer goroutine, outside the hub lock;
+	// the counters themselves are safe for concurrent use
+	websocketMessagesSent = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "websocket_messages_sent_total",
+			Help: "Messages written to WebSocket clients",
+		},
+	)
+
+	websocketSendErrors = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "websocket_send_errors_total",
+			Help: "Failed message writes to WebSocket clients",
+		},
+	)
+
+	websocketSlowClientsDropped = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "websocket_slow_clients_dropped_total",
+			Help: "WebSocket clients disconnected because their send buffer was full",
+		},
+	)
+
+	highValueEventsSuppressed = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "high_value_events_suppressed_total",
+			Help: "High-value order events not broadcast because of the per-minute cap",
+		},
+	)
+
+	storeHealthy = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "store_healthy",
+			Help: "Whether the order history store accepted the last write (1) or not (0)",
+		},
+	)
+
+	historyWritesDropped = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "history_writes_dropped_total",
+			Help: "Order history writes dropped because the retry queue was full, or the store was down at shutdown",
+		},
+	)
+
+	tsdbPointsDropped = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "tsdb_points_dropped_total",
+			Help: "Stats points dropped because the TSDB export queue was full",
+		},
+	)
+
+	tsdbWriteErrors = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "tsdb_write_errors_total",
+			Help: "Failed stats writes to the TSDB",
+		},
+	)
+
+	unknownStatusOrders = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_unknown_status_total",
+			Help: "Orders received with an unknown status, by the policy applied",
+		},
+		labelNames("policy"),
+	)
+
+	orderErrorRate = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "order_error_rate",
+			Help: "Share of orders that failed over the last five minutes",
+		},
+	)
+
+	ordersDeduplicated = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "orders_deduplicated_total",
+			Help: "Orders skipped because the same order ID was already recorded within the dedup TTL",
+		},
+	)
+
+	ordersTooOld = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "orders_too_old_total",
+			Help: "Orders received with a timestamp older than the maximum order age",
+		},
+	)
+
+	ordersUnconverted = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "orders_unconverted_total",
+			Help: "Orders left out of base currency revenue for lack of an exchange rate",
+		},
+	)
+
+	// orderLatency is created by registerMetrics, whose config sets the
+	// buckets
+	orderLatency *prometheus.HistogramVec
 )
 
-func init() {
-	prometheus.MustRegister(ordersTotal)
-	prometheus.MustRegister(websocketConnections)
-	prometheus.MustRegister(orderLatency)
+// defaultLatencyBuckets suit sub-second processing; the tail buckets catch
+// stragglers
+var defaultLatencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5}
+
+func newOrderLatency(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "order_processing_latency_seconds",
+			Help:    "Order processing latency, by order status",
+			Buckets: buckets,
+		},
+		labelNames("status"),
+	)
+}
+
+// registerMetrics creates the configurable collectors and registers them all,
+// prefixing their names with the metrics namespace and an underscore when it
+// is set. It returns the registerer for collectors created later.
+func registerMetrics(cfg Config) prometheus.Registerer {
+	reg := prometheus.DefaultRegisterer
+	if cfg.MetricsNamespace != "" {
+		reg = prometheus.WrapRegistererWithPrefix(cfg.MetricsNamespace+"_", reg)
+	}
+	orderLatency = newOrderLatency(cfg.LatencyBuckets)
+
+	reg.MustRegister(ordersTotal)
+	reg.MustRegister(revenueTotal)
+	reg.MustRegister(orderAmount)
+	reg.MustRegister(websocketConnections)
+	reg.MustRegister(hubPanics)
+	reg.MustRegister(broadcastsDropped)
+	reg.MustRegister(monitorStatsStale)
+	reg.MustRegister(broadcastPaused)
+	reg.MustRegister(orderPublishErrors)
+	reg.MustRegister(processingErrors)
+	reg.MustRegister(ordersDeadLettered)
+	reg.MustRegister(websocketCompressedConnections)
+	reg.MustRegister(websocketCloses)
+	reg.MustRegister(websocketConnectionDuration)
+	reg.MustRegister(websocketConnectionsRejected)
+	reg.MustRegister(websocketMessagesSent)
+	reg.MustRegister(websocketSendErrors)
+	reg.MustRegister(websocketSlowClientsDropped)
+	reg.MustRegister(orderLatency)
+	reg.MustRegister(unknownStatusOrders)
+	reg.MustRegister(ordersTooOld)
+	reg.MustRegister(ordersDeduplicated)
+	reg.MustRegister(orderErrorRate)
+	reg.MustRegister(ordersUnconverted)
+	reg.MustRegister(revenueByCustomer)
+	reg.MustRegister(highValueOrders)
+	reg.MustRegister(highValueEventsSuppressed)
+	reg.MustRegister(statsComputationDuration)
+	reg.MustRegister(storeHealthy)
+	reg.MustRegister(historyWritesDropped)
+	reg.MustRegister(tsdbPointsDropped)
+	reg.MustRegister(tsdbWriteErrors)
+	return reg
 }
 
-func newHub() *Hub {
-	// Initialize Redis client (simplified - in real app you'd configure properly)
-	rdb := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+// newRedisClient connects to the Redis server in the config.
+func newRedisClient(cfg Config) redis.UniversalClient {
+	return redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
 	})
+}
 
+// newHub builds a hub on the given Redis client. The hub does nothing until
+// run and processOrders are started, so it can be exercised without a
+// listener, and rdb can be a cluster client or a stand-in.
+func newHub(cfg Config, rdb redis.UniversalClient) *Hub {
 	return &Hub{
-		clients:    make(map[*websocket.Conn]bool),
-		register:   make(chan *websocket.Conn),
-		unregister: make(chan *websocket.Conn),
-		broadcast:  make(chan []byte),
-		redis:      rdb,
+		clients:        make(map[*client]bool),
+		register:       make(chan *client, cfg.HubChannelBuffer),
+		unregister:     make(chan *client, cfg.HubChannelBuffer),
+		refilter:       make(chan filterChange, cfg.HubChannelBuffer),
+		unfiltered:     make(map[*client]bool),
+		byStatus:       make(map[OrderStatus]map[*client]bool),
+		broadcast:      make(chan message, cfg.BroadcastBuffer),
+		done:           make(chan struct{}),
+		redis:          rdb,
+		channels:       cfg.RedisChannels,
+		sink:           newOrderSink(cfg, rdb),
+		publishTimeout: cfg.PublishTimeout,
+		series:         newSeriesStore(seriesCapacity),
+		alerts:         newAlertMonitor(cfg),
+		orderCounts:    newIntervalCounter(cfg.SparklineLength),
+		sla:            newSLATracker(),
+		tsdb:           newTSDBExporter(cfg),
+		orders:         newOrderBuffer(cfg.OrderBufferSize),
+		workers:        newOrderWorkers(cfg.OrderQueueSize),
+		aggregate:      newOrderAggregate(),
+		window:         newStatsWindow(cfg.StatsWindow),
+		statsWindow:    cfg.StatsWindow,
+		revenueSeries:  newRevenueSeries(),
+		outcomes:       newOutcomeWindow(errorRateWindow),
+		revenue:        newRevenueLedger(cfg.BaseCurrency, cfg.ExchangeRates),
+		customers:      newCustomerRanking(topRevenueCustomers, maxRankedCustomers),
+		history:        newHistoryStore(rdb, cfg.HistoryQueueSize, cfg.OrderTTL),
+		latencies:      newLatencyBuffer(cfg.OrderBufferSize),
+		now:            time.Now,
+		rand:           newRand(cfg.SimulateSeed),
+		sampleRand:     newSampleRand(),
+		anonymizer:     newCustomerAnonymizer(cfg.AnonymizeCustomers, cfg.CustomerSalt),
+		departed:       make(map[*client]bool),
+
+		nodeID:               cfg.NodeID,
+		unknownStatusPolicy:  cfg.UnknownStatusPolicy,
+		unknownStatusDefault: OrderStatus(cfg.UnknownStatusDefault),
+		maxOrderAge:          cfg.MaxOrderAge,
+		staleOrderPolicy:     cfg.StaleOrderPolicy,
+		highValueThreshold:   cfg.HighValueThreshold,
+		highValueTopK:        cfg.HighValueTopK,
+		highValueLimit:       &highValueLimiter{max: cfg.HighValueMaxPerMinute},
+		slowStatsThreshold:   cfg.SlowStatsThreshold,
+		latencySampleRate:    cfg.LatencySampleRate,
+		exportMaxRows:        cfg.ExportMaxRows,
+		sessionGap:           cfg.SessionGap,
+		environment:          cfg.Environment,
+		dashboardTitle:       cfg.DashboardTitle,
+		ackMaxPending:        uint64(cfg.AckMaxPending),
+		maxConnections:       cfg.MaxConnections,
+		drainGrace:           cfg.WSDrainGrace,
+		staleThreshold:       cfg.StaleStatsThreshold,
+		dedupTTL:             cfg.DedupTTL,
+		clientSendBuffer:     cfg.ClientSendBuffer,
+		simulateOrders:       cfg.SimulateOrders,
+		simulateMode:         cfg.SimulateMode,
+		simulateRate:         cfg.SimulateRate,
+		statsInterval:        cfg.SimulateInterval,
+		statsDirty:           make(chan struct{}, 1),
+		broadcastInterval:    cfg.BroadcastInterval,
 	}
 }
 
-func (h *Hub) run() {
-	for {
+// run serves the hub until ctx is cancelled, then drains the clients.
+func (h *Hub) run(ctx context.Context) {
+	defer close(h.done)
+
+	for h.step(ctx) {
+	}
+}
+
+// step handles one hub event, reporting false once ctx is cancelled. A panic
+// is logged and counted and the hub carries on with the next event, so the
+// monitor never goes quiet over one bad message.
+func (h *Hub) step(ctx context.Context) (more bool) {
+	defer recoverPanic("hub")
+	more = true
+
+	select {
+	case <-ctx.Done():
+		h.drain()
+		return false
+
+	case c := <-h.register:
+		if h.departed[c] {
+			// The client left before its registration was processed
+			delete(h.departed, c)
+			close(c.send)
+			return
+		}
+		h.mu.Lock()
+		h.clients[c] = true
+		h.index(c)
+		total := len(h.clients)
+		h.mu.Unlock()
+		if c.stream == streamOrders {
+			h.orderStreamClients.Add(1)
+		}
+		websocketConnections.Inc()
+		if c.compressed {
+			websocketCompressedConnections.Inc()
+		}
+		log.Printf("Client connected. Total connections: %d", total)
+
+	case c := <-h.unregister:
+		c.conn.Close()
+		if c.closed {
+			// Already dropped as a slow consumer
+			return
+		}
+		h.mu.RLock()
+		_, ok := h.clients[c]
+		h.mu.RUnlock()
+		if !ok {
+			h.departed[c] = true
+			return
+		}
+		log.Printf("Client disconnected. Total connections: %d", h.remove(c))
+
+	case change := <-h.refilter:
+		if change.client.closed {
+			return
+		}
+		h.mu.Lock()
+		_, registered := h.clients[change.client]
+		if registered {
+			h.unindex(change.client)
+		}
+		// A change that overtook its registration is picked up by register
+		change.client.filter = change.filter
+		if registered {
+			h.index(change.client)
+		}
+		h.mu.Unlock()
+
+	case msg := <-h.broadcast:
+		if h.paused.Load() && !msg.unpausable {
+			return
+		}
+		for _, c := range h.deliver(msg) {
+			websocketSlowClientsDropped.Inc()
+			total := h.remove(c)
+			log.Printf("Dropping slow client %s. Total connections: %d", c.conn.RemoteAddr(), total)
+		}
+	}
+	return
+}
+
+// deliver queues a message for every client that accepts it and returns the
+// clients whose queue was full. Order events only visit the unfiltered
+// clients and those filtering for the order's status.
+func (h *Hub) deliver(msg message) (slow []*client) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	send := func(c *client) {
+		if c.stream != msg.stream {
+			return
+		}
 		select {
-		case conn := <-h.register:
-			h.mu.Lock()
-			h.clients[conn] = true
-			h.mu.Unlock()
-			websocketConnections.Inc()
-			log.Printf("Client connected. Total connections: %d", len(h.clients))
-
-		case conn := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[conn]; ok {
-				delete(h.clients, conn)
-				conn.Close()
-			}
-			h.mu.Unlock()
-			websocketConnections.Dec()
-			log.Printf("Client disconnected. Total connections: %d", len(h.clients))
-
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for conn := range h.clients {
-				err := conn.WriteMessage(websocket.TextMessage, message)
-				if err != nil {
-					conn.Close()
-					delete(h.clients, conn)
-				}
-			}
-			h.mu.RUnlock()
+		case c.send <- msg.data:
+		default:
+			slow = append(slow, c)
+			return
+		}
+		if msg.seq > 0 {
+			h.trackDelivery(c, msg.seq)
+		}
+	}
+	if msg.status == "" {
+		for c := range h.clients {
+			send(c)
+		}
+		return slow
+	}
+	for c := range h.unfiltered {
+		if c.inRoom(msg) {
+			send(c)
+		}
+	}
+	for c := range h.byStatus[msg.status] {
+		if c.inRoom(msg) {
+			send(c)
 		}
 	}
+	return slow
 }
 
-// Simulate order processing with Redis pub/sub
-func (h *Hub) processOrders() {
-	ticker := time.NewTicker(2 * time.Second)
+// recoverPanic logs and counts a panic in one of the long-running loops.
+// Defer it directly around a unit of work that may be skipped.
+func recoverPanic(where string) {
+	if r := recover(); r != nil {
+		hubPanics.Inc()
+		log.Printf("Recovered panic in %s: %v\n%s", where, r, debug.Stack())
+	}
+}
+
+// Close releases the hub's outside connections, the order sink and the Redis
+// client, once the subscriber, order workers and history writer have
+// stopped. Later calls return the first call's result.
+func (h *Hub) Close() error {
+	h.closeOnce.Do(func() {
+		h.closeErr = errors.Join(h.sink.Close(), h.redis.Close())
+	})
+	return h.closeErr
+}
+
+// remove deregisters a client, stops its writer and closes the connection.
+// It returns the number of clients left. Only called by run().
+func (h *Hub) remove(c *client) int {
+	c.closed = true
+	close(c.send)
+	c.conn.Close()
+	return h.forget(c)
+}
+
+// forget deregisters a client whose writer is already stopped and updates
+// the connection metrics. It returns the number of clients left. Only
+// called by run().
+func (h *Hub) forget(c *client) int {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.unindex(c)
+	total := len(h.clients)
+	h.mu.Unlock()
+
+	if c.stream == streamOrders {
+		h.orderStreamClients.Add(-1)
+	}
+	websocketConnectionDuration.Observe(time.Since(c.connectedAt).Seconds())
+	websocketConnections.Dec()
+	if c.compressed {
+		websocketCompressedConnections.Dec()
+	}
+	return total
+}
+
+// publish queues a message for run() to broadcast. It never blocks: when
+// the queue is full, or the hub has stopped, the message is dropped and
+// counted, so recording and stats never stall behind slow delivery.
+func (h *Hub) publish(msg message) {
+	select {
+	case h.broadcast <- msg:
+	default:
+		broadcastsDropped.Inc()
+	}
+}
+
+// ConnectionCount returns the number of registered clients. Code outside
+// run() must read the clients map through this or SnapshotConnections.
+func (h *Hub) ConnectionCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.clients)
+}
+
+// SnapshotConnections returns the registered clients at this moment. The
+// slice is the caller's; clients in it may disconnect at any time.
+func (h *Hub) SnapshotConnections() []*client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// trackDelivery notes that an order event was sent to the client and drops
+// acknowledging clients that fall too far behind.
+func (h *Hub) trackDelivery(c *client, seq uint64) {
+	if h.ackMaxPending == 0 || !c.acking.Load() {
+		return
+	}
+	if pending := c.delivered(seq); pending > h.ackMaxPending {
+		log.Printf("Disconnecting %s: %d order events unacknowledged", c.conn.RemoteAddr(), pending)
+		c.conn.Close()
+	}
+}
+
+// Simulated traffic shapes
+const (
+	simulateUniform = "uniform"
+	simulatePoisson = "poisson"
+)
+
+// processOrders publishes a stats snapshot, and any alerts it raises, every
+// stats interval. With uniform simulation it first fabricates an order;
+// Poisson simulation runs on its own schedule.
+func (h *Hub) processOrders(ctx context.Context) {
+	ticker := time.NewTicker(h.statsInterval)
 	defer ticker.Stop()
 
+	if h.simulateOrders && h.simulateMode == simulatePoisson {
+		go h.simulateArrivals(ctx)
+	}
+
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			// Simulate new order
-			order := Order{
-				ID:        fmt.Sprintf("order_%d", time.Now().Unix()),
-				Customer: fmt.Sprintf("customer_%d", rand.Intn(100)),
-				Amount:    rand.Float64() * 1000,
-				Status:    []string{"pending", "processing", "completed", "failed"}[rand.Intn(4)],
-				Timestamp: time.Now(),
-			}
+			h.tick(ctx)
+		}
+	}
+}
+
+// tick runs one stats interval. A panic skips the rest of the interval only.
+func (h *Hub) tick(ctx context.Context) {
+	defer recoverPanic("stats loop")
 
-			// Publish to Redis (simplified)
-			orderJSON, _ := json.Marshal(order)
-			h.redis.Publish(context.Background(), "orders", orderJSON)
+	if h.simulateOrders && h.simulateMode == simulateUniform {
+		h.simulateOrder(ctx)
+	}
 
-			// Update metrics
-			ordersTotal.WithLabelValues(order.Status).Inc()
+	// Generate stats and broadcast
+	h.orderCounts.roll()
+	stats := h.currentStats()
+	h.series.add(h.now(), stats)
+	h.tsdb.enqueue(time.Now(), stats)
+	h.markStatsChanged()
 
-			// Simulate processing latency
-			latency := time.Duration(rand.Intn(1000)) * time.Millisecond
-			orderLatency.Observe(latency.Seconds())
+	for _, alert := range h.alerts.evaluate(stats) {
+		log.Printf("Alert %s %s: value %.2f, threshold %.2f", alert.Name, alert.State, alert.Value, alert.Threshold)
+		alertJSON, _ := newEnvelope(eventAlert, alert)
+		h.publish(message{data: alertJSON})
+	}
+}
 
-			// Generate stats and broadcast
-			stats := h.generateStats()
-			statsJSON, _ := json.Marshal(stats)
-			h.broadcast <- statsJSON
+// simulateArrivals fabricates orders as a Poisson process: inter-arrival
+// times are exponentially distributed around the mean rate, so orders
+// cluster into bursts and lulls the way real traffic does.
+func (h *Hub) simulateArrivals(ctx context.Context) {
+	for {
+		wait := time.Duration(h.rand.ExpFloat64() / h.simulateRate * float64(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
 		}
+		h.simulateOrder(ctx)
+	}
+}
+
+// Simulate order processing with Redis pub/sub
+func (h *Hub) simulateOrder(ctx context.Context) {
+	order := Order{
+		ID:          newOrderID(),
+		Customer:    fmt.Sprintf("customer_%d", h.rand.Intn(100)),
+		Amount:      h.rand.Float64() * 1000,
+		Status:      orderStatuses[h.rand.Intn(len(orderStatuses))],
+		Timestamp:   h.now(),
+		ProcessedBy: h.nodeID,
+		Tenant:      h.channels[0],
+	}
+	// Roughly a third are express orders with a tight SLA
+	if h.rand.Intn(3) == 0 {
+		order.SLASeconds = 0.8
 	}
+
+	h.publishOrder(ctx, order)
+
+	// Simulate processing latency and update metrics
+	latency := time.Duration(h.rand.Intn(1000)) * time.Millisecond
+	h.workers.enqueue(ctx, ingestItem{order: order, at: order.Timestamp.Add(latency), latency: latency})
+}
+
+// currentStats returns a fresh stats snapshot. Concurrent callers (the
+// broadcaster and API pollers) share one computation rather than each
+// repeating it.
+func (h *Hub) currentStats() Stats {
+	v, _, _ := h.statsGroup.Do("stats", func() (interface{}, error) {
+		start := time.Now()
+		stats := h.generateStats()
+		elapsed := time.Since(start)
+
+		statsComputationDuration.Observe(elapsed.Seconds())
+		if h.slowStatsThreshold > 0 && elapsed > h.slowStatsThreshold {
+			log.Printf("Slow stats computation: took %s (threshold %s)", elapsed, h.slowStatsThreshold)
+		}
+		return stats, nil
+	})
+	return v.(Stats)
 }
 
 func (h *Hub) generateStats() Stats {
-	// Simplified stats generation
-	return Stats{
-		TotalOrders:  rand.Intn(1000) + 500,
-		TotalRevenue: rand.Float64() * 100000,
-		ActiveOrders: rand.Intn(50) + 10,
-		AverageOrder: rand.Float64() * 200,
-		ErrorRate:    rand.Float64() * 0.05,
-		QueueDepth:   rand.Intn(20) + 5,
+	stats := Stats{
+		QueueDepth:   h.workers.depth(),
+		RecentCounts: h.orderCounts.snapshot(),
+	}
+	h.aggregate.fill(&stats)
+	if h.window != nil {
+		h.window.fill(&stats, h.now())
+		stats.StatsWindow = h.statsWindow.String()
+	}
+	stats.ErrorRate = h.outcomes.rate(h.now())
+	h.revenue.fill(&stats)
+	stats.sanitize()
+	orderErrorRate.Set(stats.ErrorRate)
+	return stats
+}
+
+// sanitize keeps the snapshot encodable and the dashboard's toFixed calls
+// working: json.Marshal rejects NaN and ±Inf, which huge unvalidated Redis
+// amounts can sum to, so those become 0, and the error rate is clamped to
+// [0, 1]. The average is recomputed, so it is 0 without orders.
+func (s *Stats) sanitize() {
+	s.TotalRevenue = finite(s.TotalRevenue)
+	s.TotalRevenueBase = finite(s.TotalRevenueBase)
+	for i := range s.RevenueByCurrency {
+		s.RevenueByCurrency[i].Amount = finite(s.RevenueByCurrency[i].Amount)
+	}
+	s.AverageOrder = 0
+	if s.TotalOrders > 0 {
+		s.AverageOrder = s.TotalRevenue / float64(s.TotalOrders)
 	}
+	s.ErrorRate = math.Max(0, math.Min(1, finite(s.ErrorRate)))
+}
+
+// finite returns v, or 0 when it is NaN or infinite.
+func finite(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
 }
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for demo
-	},
+	CheckOrigin: originAllowed,
+}
+
+// compressionLevel is the deflate level of compressed connections
+var compressionLevel = flate.BestSpeed
+
+// allowedOrigins are the origins browsers may open WebSocket connections
+// from. Empty allows only the server's own origin; a "*" entry allows any.
+var allowedOrigins []string
+
+// originAllowed checks the Origin header against allowedOrigins. Requests
+// without one come from non-browser clients and are allowed.
+func originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	origin = strings.TrimSuffix(origin, "/")
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// offersCompression reports whether the client offered permessage-deflate,
+// which the upgrader accepts whenever compression is enabled.
+func offersCompression(r *http.Request) bool {
+	for _, header := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(ext, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
 }
 
+// handleWebSocket serves /ws: stats, alerts and order events.
 func handleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	serveWebSocket(hub, streamStats, w, r)
+}
+
+// serveWebSocket upgrades and registers a client of the given stream.
+func serveWebSocket(hub *Hub, stream string, w http.ResponseWriter, r *http.Request) {
+	if !originAllowed(r) {
+		log.Printf("Rejecting WebSocket from origin %q", r.Header.Get("Origin"))
+		writeError(w, http.StatusForbidden, "origin not allowed")
+		return
+	}
+	filter, err := queryStatusFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	room := r.URL.Query().Get("room")
+	if len(room) > maxRoomLength {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("room must be at most %d bytes", maxRoomLength))
+		return
+	}
+	// Registrations still in flight aren't counted, so the cap is soft by
+	// at most the register channel's buffer
+	if hub.maxConnections > 0 && hub.ConnectionCount() >= hub.maxConnections {
+		websocketConnectionsRejected.Inc()
+		w.Header().Set("Retry-After", "10")
+		writeError(w, http.StatusServiceUnavailable, "too many connections")
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
 
-	hub.register <- conn
+	c := &client{
+		conn:        conn,
+		compressed:  upgrader.EnableCompression && offersCompression(r),
+		connectedAt: time.Now(),
+		send:        make(chan []byte, hub.clientSendBuffer),
+		filter:      filter,
+		room:        room,
+		stream:      stream,
+	}
+	if c.compressed {
+		// The level was range checked at startup
+		conn.SetCompressionLevel(compressionLevel)
+	}
+	conn.SetCloseHandler(c.handleClose)
+	conn.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+	c.extendReadDeadline()
+
+	// Populate the dashboard without waiting for the next tick. The send
+	// buffer is empty, so this never blocks, and a failed write surfaces in
+	// the read loop like any other.
+	if stream == streamStats {
+		if statsJSON, err := hub.statsMessage(); err == nil {
+			c.send <- statsJSON
+		}
+	}
+	hub.register <- c
+
+	stop := make(chan struct{})
+	go c.heartbeat(stop)
+	go c.writeLoop()
 
 	// Keep connection alive
 	go func() {
 		defer func() {
-			hub.unregister <- conn
+			close(stop)
+			hub.unregister <- c
 		}()
 
 		for {
-			_, _, err := conn.ReadMessage()
+			_, data, err := conn.ReadMessage()
 			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					log.Printf("Dropping %s: no pong within %s", conn.RemoteAddr(), pingInterval+pongTimeout)
+					break
+				}
+				if websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+					// The peer vanished without a close frame
+					websocketCloses.WithLabelValues(closeCodeLabel(websocket.CloseAbnormalClosure)).Inc()
+				}
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseServiceRestart, websocket.CloseAbnormalClosure) {
 					log.Printf("WebSocket error: %v", err)
 				}
 				break
 			}
+			c.handleCommand(hub, data)
 		}
 	}()
 }
 
-func main() {
-	hub := newHub()
-	go hub.run()
-	go hub.processOrders()
-
-	// WebSocket endpoint
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handleWebSocket(hub, w, r)
-	})
-
-	// Prometheus metrics endpoint
-	http.Handle("/metrics", promhttp.Handler())
-
-	// Simple dashboard endpoint
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `
+// handleDashboard serves the monitoring dashboard page.
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, `
 <!DOCTYPE html>
 <html>
 <head>
+    <meta charset="utf-8">
     <title>E-commerce Monitoring Dashboard</title>
     <script>
-        const ws = new WebSocket('ws://localhost:8080/ws');
-        ws.onmessage = function(event) {
-            const stats = JSON.parse(event.data);
+        // Pass the dashboard's own ?token= on when auth is enabled, and its
+        // ?room= to follow a single region's orders
+        const page = new URLSearchParams(location.search);
+        const params = new URLSearchParams();
+        ['token', 'room'].forEach(function(name) {
+            if (page.get(name)) params.set(name, page.get(name));
+        });
+        const wsURL = (location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/ws' +
+            (params.toString() ? '?' + params : '');
+
+        // Reconnect with exponential backoff and full jitter, so a fleet of
+        // dashboards doesn't stampede the server as it comes back
+        const reconnectBase = 1000, reconnectMax = 30000;
+        let reconnectAttempt = 0;
+        // Set by a shutdown envelope, so the close that follows isn't
+        // reported as a failure
+        let restarting = false;
+
+        function connect() {
+            const ws = new WebSocket(wsURL);
+            ws.onopen = function() {
+                reconnectAttempt = 0;
+                restarting = false;
+                document.getElementById('connection').style.display = 'none';
+            };
+            ws.onmessage = onMessage;
+            ws.onerror = function() {
+                ws.close();
+            };
+            ws.onclose = function() {
+                const delay = Math.random() * Math.min(reconnectMax, reconnectBase * 2 ** reconnectAttempt);
+                reconnectAttempt++;
+                const banner = document.getElementById('connection');
+                banner.textContent = (restarting ? 'Server restarting' : 'Disconnected') +
+                    ', reconnecting in ' + Math.ceil(delay / 1000) + 's';
+                banner.style.display = 'block';
+                setTimeout(connect, delay);
+            };
+        }
+        connect();
+
+        function onMessage(event) {
+            const msg = JSON.parse(event.data);
+            switch (msg.type) {
+            case 'alert':
+                showAlert(msg.data);
+                break;
+            case 'high_value_order':
+                showHighValueOrder(msg.data.order);
+                break;
+            case 'stats':
+                showStats(msg.data);
+                break;
+            case 'error':
+                showError(msg.data);
+                break;
+            case 'paused':
+                document.getElementById('paused').style.display = msg.data.paused ? 'block' : 'none';
+                break;
+            case 'shutdown':
+                restarting = true;
+                const connection = document.getElementById('connection');
+                connection.textContent = 'Server restarting';
+                connection.style.display = 'block';
+                break;
+            case 'stale':
+                const stale = document.getElementById('stale');
+                stale.textContent = 'Stats frozen: no orders since ' + new Date(msg.data.last_update).toLocaleTimeString();
+                stale.style.display = msg.data.stale ? 'block' : 'none';
+                break;
+            }
+        }
+
+        function showStats(stats) {
             document.getElementById('total-orders').textContent = stats.total_orders;
+            document.getElementById('lifetime-orders').textContent = stats.lifetime_orders;
             document.getElementById('total-revenue').textContent = '$' + stats.total_revenue.toFixed(2);
             document.getElementById('active-orders').textContent = stats.active_orders;
             document.getElementById('average-order').textContent = '$' + stats.average_order.toFixed(2);
+            document.querySelectorAll('.window').forEach(function(label) {
+                label.textContent = stats.stats_window ? ' (last ' + stats.stats_window + ')' : '';
+            });
             document.getElementById('error-rate').textContent = (stats.error_rate * 100).toFixed(2) + '%';
             document.getElementById('queue-depth').textContent = stats.queue_depth;
-        };
+            document.getElementById('order-sparkline').textContent = sparkline(stats.recent_counts || []);
+            const rows = document.getElementById('status-counts');
+            rows.textContent = '';
+            Object.keys(stats.status_counts || {}).sort().forEach(function(status) {
+                const row = rows.insertRow();
+                row.insertCell().textContent = status;
+                row.insertCell().textContent = stats.status_counts[status];
+            });
+        }
+
+        fetch('/api/dashboard/config').then(function(resp) {
+            return resp.json();
+        }).then(function(config) {
+            document.title = config.title;
+            document.getElementById('title').textContent = config.title;
+            if (config.environment) {
+                const banner = document.getElementById('environment');
+                banner.textContent = config.environment.toUpperCase();
+                banner.style.background = config.color;
+                banner.style.display = 'block';
+            }
+        });
+
+        function sparkline(counts) {
+            const blocks = '▁▂▃▄▅▆▇█';
+            const max = Math.max(1, ...counts);
+            return counts.map(function(c) {
+                return blocks[Math.round(c / max * (blocks.length - 1))];
+            }).join('');
+        }
+
+        function showAlert(alert) {
+            const item = document.createElement('li');
+            item.textContent = new Date().toLocaleTimeString() + ' ' + alert.name + ' ' + alert.state +
+                ' (value ' + alert.value.toFixed(2) + ', threshold ' + alert.threshold.toFixed(2) + ')';
+            const list = document.getElementById('alerts');
+            list.insertBefore(item, list.firstChild);
+        }
+
+        function showError(error) {
+            const item = document.createElement('li');
+            item.textContent = new Date(error.at).toLocaleTimeString() + ' ' + error.reason +
+                (error.order_id ? ' ' + error.order_id : '') + ': ' + error.error;
+            const list = document.getElementById('errors');
+            list.insertBefore(item, list.firstChild);
+            while (list.children.length > 20) {
+                list.removeChild(list.lastChild);
+            }
+        }
+
+        function showHighValueOrder(order) {
+            const item = document.createElement('li');
+            item.textContent = new Date(order.timestamp).toLocaleTimeString() + ' ' + order.id + ' ' +
+                order.customer + ' $' + order.amount.toFixed(2) + ' (' + order.status + ')';
+            const list = document.getElementById('high-value-orders');
+            list.insertBefore(item, list.firstChild);
+            while (list.children.length > 20) {
+                list.removeChild(list.lastChild);
+            }
+        }
     </script>
 </head>
 <body>
-    <h1>E-commerce Monitoring Dashboard</h1>
+    <div id="environment" style="display: none; color: #fff; padding: 4px 8px; font-weight: bold;"></div>
+    <h1 id="title">E-commerce Monitoring Dashboard</h1>
+    <div id="connection" style="display: none; background: #c0392b; color: #fff; padding: 4px 8px;"></div>
+    <div id="paused" style="display: none; background: #7f8c8d; color: #fff; padding: 4px 8px;">Updates paused for maintenance</div>
+    <div id="stale" style="display: none; background: #e67e22; color: #fff; padding: 4px 8px;"></div>
     <div>
         <h2>Real-time Stats</h2>
-        <p>Total Orders: <span id="total-orders">0</span></p>
-        <p>Total Revenue: <span id="total-revenue">$0</span></p>
-        <p>Active Orders: <span id="active-orders">0</span></p>
-        <p>Average Order: <span id="average-order">$0</span></p>
+        <p>Total Orders<span class="window"></span>: <span id="total-orders">0</span></p>
+        <p>All-time Orders: <span id="lifetime-orders">0</span></p>
+        <p>Total Revenue<span class="window"></span>: <span id="total-revenue">$0</span></p>
+        <p>All-time Active Orders: <span id="active-orders">0</span></p>
+        <p>Average Order<span class="window"></span>: <span id="average-order">$0</span></p>
         <p>Error Rate: <span id="error-rate">0%</span></p>
         <p>Queue Depth: <span id="queue-depth">0</span></p>
+        <p>Recent Orders: <span id="order-sparkline"></span></p>
+        <table>
+            <thead><tr><th>Status</th><th>All-time Orders</th></tr></thead>
+            <tbody id="status-counts"></tbody>
+        </table>
+    </div>
+    <div>
+        <h2>Alerts</h2>
+        <ul id="alerts"></ul>
+    </div>
+    <div>
+        <h2>High-value Orders</h2>
+        <ul id="high-value-orders"></ul>
+    </div>
+    <div>
+        <h2>Processing Errors</h2>
+        <ul id="errors"></ul>
     </div>
     <p><a href="/metrics">Prometheus Metrics</a></p>
 </body>
 </html>
-		`)
+	`)
+}
+
+func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	upgrader.EnableCompression = cfg.WSCompression
+	if cfg.WSCompressionLevel < flate.HuffmanOnly || cfg.WSCompressionLevel > flate.BestCompression {
+		log.Printf("ws-compression-level %d is outside %d..%d, using %d",
+			cfg.WSCompressionLevel, flate.HuffmanOnly, flate.BestCompression, compressionLevel)
+	} else {
+		compressionLevel = cfg.WSCompressionLevel
+	}
+	upgrader.ReadBufferSize = cfg.WSReadBuffer
+	upgrader.WriteBufferSize = cfg.WSWriteBuffer
+	// Write buffers are only held while a message is being written, so
+	// pooling them keeps idle dashboards from each pinning one
+	upgrader.WriteBufferPool = &sync.Pool{}
+	authToken = cfg.AuthToken
+	allowedOrigins = cfg.AllowedOrigins
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	metrics := registerMetrics(cfg)
+	hub := newHub(cfg, newRedisClient(cfg))
+	if err := hub.selfTest(ctx); err != nil {
+		if cfg.StrictStartup {
+			log.Fatalf("Redis pub/sub self-test failed: %v", err)
+		}
+		log.Printf("Redis pub/sub self-test failed, continuing without it: %v", err)
+	} else {
+		log.Println("Redis pub/sub self-test passed")
+	}
+	hub.workers.start(hub, cfg.WorkerCount)
+	// Read at scrape time so autoscalers see the queue as it is, not as of
+	// the last stats tick
+	metrics.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "order_queue_depth",
+			Help: "Orders queued for the order workers",
+		},
+		func() float64 { return float64(hub.workers.depth()) },
+	))
+	go hub.run(ctx)
+	go hub.processOrders(ctx)
+	go hub.broadcastStats(ctx)
+	if hub.staleThreshold > 0 {
+		hub.markUpdated(time.Now())
+		go hub.watchStaleness(ctx)
+	}
+	go hub.history.run()
+	if hub.tsdb != nil {
+		go hub.tsdb.run()
+	}
+	sub := newSubscriber(hub)
+	go sub.run(ctx)
+
+	// Our own mux: importing net/http/pprof registers its handlers on the
+	// default one, which must not be served unless --debug is set
+	mux := http.NewServeMux()
+
+	// WebSocket endpoint
+	mux.HandleFunc("/ws", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(hub, w, r)
+	}))
+	mux.HandleFunc("/ws/orders", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleOrderStream(hub, w, r)
+	}))
+
+	// REST API, callable cross-origin from the allowed origins
+	api := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, withCORS(handler))
+	}
+	api("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+		handleStats(hub, w, r)
+	})
+	api("/api/admin/alerts", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleAlertThresholds(hub, w, r)
+	}))
+	api("/admin/pause", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleSetPaused(hub, true, w, r)
+	}))
+	api("/admin/resume", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleSetPaused(hub, false, w, r)
+	}))
+	api("/api/overview", func(w http.ResponseWriter, r *http.Request) {
+		handleOverview(hub, w, r)
+	})
+	api("/api/apdex", func(w http.ResponseWriter, r *http.Request) {
+		handleApdex(hub, w, r)
+	})
+	api("/api/dashboard/config", func(w http.ResponseWriter, r *http.Request) {
+		handleDashboardConfig(hub, w, r)
+	})
+	api("/api/customers/repeat-rate", func(w http.ResponseWriter, r *http.Request) {
+		handleRepeatRate(hub, w, r)
+	})
+	api("/api/orders", func(w http.ResponseWriter, r *http.Request) {
+		handleOrders(hub, w, r)
+	})
+	api("/api/orders/export", func(w http.ResponseWriter, r *http.Request) {
+		handleOrdersExport(hub, w, r)
+	})
+	api("/api/orders/high-value", func(w http.ResponseWriter, r *http.Request) {
+		handleHighValueOrders(hub, w, r)
+	})
+	api("/api/orders/recent", func(w http.ResponseWriter, r *http.Request) {
+		handleRecentOrders(hub, w, r)
+	})
+	api("/replay", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleReplay(hub, w, r)
+	}))
+	// Remote addresses are not public
+	api("/connections", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleConnections(hub, w, r)
+	}))
+	api("/stats", func(w http.ResponseWriter, r *http.Request) {
+		handlePolledStats(hub, w, r)
+	})
+	ingestLimiter := newIPRateLimiter(cfg.IngestRate, cfg.IngestBurst)
+	api("/orders", ingestLimiter.limit(func(w http.ResponseWriter, r *http.Request) {
+		handleSubmitOrder(hub, w, r)
+	}))
+	api("/orders/recent", func(w http.ResponseWriter, r *http.Request) {
+		handleRecentOrdersArray(hub, w, r)
+	})
+	api("/orders/deadletter", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		handleDeadLetters(hub, w, r)
+	}))
+	api("/orders/export.csv", func(w http.ResponseWriter, r *http.Request) {
+		handleRecentOrdersCSV(hub, w, r)
+	})
+	api("/orders/", func(w http.ResponseWriter, r *http.Request) {
+		handleGetOrder(hub, w, r)
+	})
+	api("/api/sessions/basket-distribution", func(w http.ResponseWriter, r *http.Request) {
+		handleBasketDistribution(hub, w, r)
+	})
+	api("/api/sla/breaches", func(w http.ResponseWriter, r *http.Request) {
+		handleSLABreaches(hub, w, r)
+	})
+	api("/stats/timeseries", func(w http.ResponseWriter, r *http.Request) {
+		handleRevenueSeries(hub, w, r)
 	})
+	api("/api/timeseries", func(w http.ResponseWriter, r *http.Request) {
+		handleTimeseries(hub, w, r)
+	})
+
+	api("/openapi.json", handleOpenAPI)
+	api("/healthz", handleHealthz)
+	api("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleReadyz(hub, w, r)
+	})
+
+	// Prometheus metrics endpoint
+	mux.HandleFunc("/metrics", requireAuth(promhttp.Handler().ServeHTTP))
+	// Debug endpoints answer 404 unless enabled
+	debugReset := http.NotFound
+	profiles := map[string]http.HandlerFunc{
+		"/debug/pprof/":        http.NotFound,
+		"/debug/pprof/cmdline": http.NotFound,
+		"/debug/pprof/profile": http.NotFound,
+		"/debug/pprof/symbol":  http.NotFound,
+		"/debug/pprof/trace":   http.NotFound,
+	}
+	if cfg.Debug {
+		debugReset = requireAuth(func(w http.ResponseWriter, r *http.Request) {
+			handleDebugReset(hub, w, r)
+		})
+		profiles["/debug/pprof/"] = requireAuth(pprof.Index)
+		profiles["/debug/pprof/cmdline"] = requireAuth(pprof.Cmdline)
+		profiles["/debug/pprof/profile"] = requireAuth(pprof.Profile)
+		profiles["/debug/pprof/symbol"] = requireAuth(pprof.Symbol)
+		profiles["/debug/pprof/trace"] = requireAuth(pprof.Trace)
+	}
+	mux.HandleFunc("/debug/reset", debugReset)
+	for pattern, handler := range profiles {
+		mux.HandleFunc(pattern, handler)
+	}
+
+	mux.HandleFunc("/", handleDashboard)
 
-	log.Println("Starting server on :8080")
-	log.Println("WebSocket endpoint: ws://localhost:8080/ws")
-	log.Println("Dashboard: http://localhost:8080")
-	log.Println("Metrics: http://localhost:8080/metrics")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	host := cfg.HTTPAddr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	scheme, wsScheme := "http", "ws"
+	if cfg.TLSCert != "" {
+		scheme, wsScheme = "https", "wss"
+	}
+	log.Printf("Starting server on %s", cfg.HTTPAddr)
+	log.Printf("WebSocket endpoint: %s://%s/ws", wsScheme, host)
+	log.Printf("Dashboard: %s://%s", scheme, host)
+	log.Printf("Metrics: %s://%s/metrics", scheme, host)
+	srv := &http.Server{
+		Addr:    cfg.HTTPAddr,
+		Handler: withAccessLog(withSecurityHeaders(securityHeaders(cfg.SecurityHeaders), mux)),
+	}
+	go func() {
+		var err error
+		if cfg.TLSCert != "" {
+			err = srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Hijacked WebSocket connections are not tracked by the server; the hub
+	// closes them itself
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	sub.wait(shutdownCtx)
+	if !hub.workers.shutdown(shutdownCtx) {
+		log.Printf("Order workers did not drain within the shutdown timeout, %d orders unrecorded", hub.workers.depth())
+	}
+	if !hub.history.shutdown(shutdownCtx) {
+		log.Println("Order history writer did not stop within the shutdown timeout")
+	}
+	if err := hub.Close(); err != nil {
+		log.Printf("Hub close error: %v", err)
+	}
+	select {
+	case <-hub.done:
+	case <-shutdownCtx.Done():
+		log.Println("Hub did not stop within the shutdown timeout")
+	}
+	log.Println("Shutdown complete")
 }
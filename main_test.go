@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMain(m *testing.M) {
+	// registerMetrics creates the latency histogram; tests use it unregistered
+	orderLatency = newOrderLatency(defaultLatencyBuckets)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testNow is the fixed time test hubs start at
+var testNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestHub builds a hub configured by args, as on the command line, on a
+// fresh miniredis. Its clock is stopped at testNow.
+func newTestHub(t *testing.T, args ...string) (*Hub, *miniredis.Miniredis) {
+	t.Helper()
+
+	cfg, err := loadConfig(args)
+	if err != nil {
+		t.Fatalf("loadConfig(%q): %v", args, err)
+	}
+	mr := miniredis.RunT(t)
+	hub := newHub(cfg, redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	hub.now = func() time.Time { return testNow }
+	t.Cleanup(func() { hub.Close() })
+	return hub, mr
+}
+
+// serveTestHub runs the hub and serves /ws and /ws/orders from a test server,
+// returning its ws:// base URL. Both stop when the test ends.
+func serveTestHub(t *testing.T, hub *Hub) string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go hub.run(ctx)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(hub, w, r)
+	})
+	mux.HandleFunc("/ws/orders", func(w http.ResponseWriter, r *http.Request) {
+		handleOrderStream(hub, w, r)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		cancel()
+		<-hub.done
+		srv.Close()
+	})
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dialTestHub connects a WebSocket client, closed when the test ends.
+func dialTestHub(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readEnvelope reads the next message, failing the test after a second.
+func readEnvelope(t *testing.T, conn *websocket.Conn) Envelope {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var env Envelope
+	if err := conn.ReadJSON(&env); err != nil {
+		t.Fatalf("reading envelope: %v", err)
+	}
+	return env
+}
+
+// waitFor polls cond until it holds, failing the test after two seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// histogramCount returns how many observations a histogram has seen.
+func histogramCount(t *testing.T, h prometheus.Histogram) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// testOrder returns an order recorded at testNow.
+func testOrder(id string, status OrderStatus, amount float64) Order {
+	return Order{ID: id, Customer: "customer_" + id, Amount: amount, Status: status, Timestamp: testNow, Tenant: "orders"}
+}
+
+// decodeJSON decodes a recorded response body.
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func TestGenerateStats(t *testing.T) {
+	tests := []struct {
+		name        string
+		orders      []Order
+		wantTotal   int
+		wantRevenue float64
+		wantActive  int
+		wantAverage float64
+		wantErrRate float64
+		wantCounts  StatusCounts
+	}{
+		{
+			name:       "no orders",
+			wantCounts: StatusCounts{"pending": 0, "processing": 0, "completed": 0, "failed": 0},
+		},
+		{
+			name: "mixed statuses",
+			orders: []Order{
+				testOrder("1", StatusPending, 10),
+				testOrder("2", StatusProcessing, 20),
+				testOrder("3", StatusCompleted, 30),
+				testOrder("4", StatusFailed, 40),
+			},
+			wantTotal:   4,
+			wantRevenue: 60,
+			wantActive:  2,
+			wantAverage: 15,
+			wantErrRate: 0.25,
+			wantCounts:  StatusCounts{"pending": 1, "processing": 1, "completed": 1, "failed": 1},
+		},
+		{
+			name: "all completed",
+			orders: []Order{
+				testOrder("1", StatusCompleted, 5),
+				testOrder("2", StatusCompleted, 15),
+			},
+			wantTotal:   2,
+			wantRevenue: 20,
+			wantAverage: 10,
+			wantCounts:  StatusCounts{"pending": 0, "processing": 0, "completed": 2, "failed": 0},
+		},
+		{
+			name: "all failed",
+			orders: []Order{
+				testOrder("1", StatusFailed, 1),
+				testOrder("2", StatusFailed, 3),
+			},
+			wantTotal:   2,
+			wantErrRate: 1,
+			wantCounts:  StatusCounts{"pending": 0, "processing": 0, "completed": 0, "failed": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, _ := newTestHub(t)
+			for _, order := range tt.orders {
+				if !hub.recordOrder(order, testNow, 0) {
+					t.Fatalf("order %s not recorded", order.ID)
+				}
+			}
+
+			stats := hub.generateStats()
+			if stats.TotalOrders != tt.wantTotal || stats.LifetimeOrders != tt.wantTotal {
+				t.Errorf("total_orders = %d, lifetime_orders = %d, want %d", stats.TotalOrders, stats.LifetimeOrders, tt.wantTotal)
+			}
+			if stats.TotalRevenue != tt.wantRevenue {
+				t.Errorf("total_revenue = %v, want %v", stats.TotalRevenue, tt.wantRevenue)
+			}
+			if stats.ActiveOrders != tt.wantActive {
+				t.Errorf("active_orders = %d, want %d", stats.ActiveOrders, tt.wantActive)
+			}
+			if stats.AverageOrder != tt.wantAverage {
+				t.Errorf("average_order = %v, want %v", stats.AverageOrder, tt.wantAverage)
+			}
+			if stats.ErrorRate != tt.wantErrRate {
+				t.Errorf("error_rate = %v, want %v", stats.ErrorRate, tt.wantErrRate)
+			}
+			if len(stats.StatusCounts) != len(tt.wantCounts) {
+				t.Errorf("status_counts = %v, want %v", stats.StatusCounts, tt.wantCounts)
+			}
+			for status, want := range tt.wantCounts {
+				if got := stats.StatusCounts[status]; got != want {
+					t.Errorf("status_counts[%s] = %d, want %d", status, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateStatsIsDeterministic(t *testing.T) {
+	hub, _ := newTestHub(t)
+	for i, status := range orderStatuses {
+		hub.recordOrder(testOrder(string(rune('a'+i)), status, float64(i+1)), testNow, 0)
+	}
+
+	first, err := json.Marshal(hub.generateStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := json.Marshal(hub.generateStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != string(second) {
+		t.Errorf("stats changed between calls:\n%s\n%s", first, second)
+	}
+}
+
+func TestStatsWindowLabelled(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.recordOrder(testOrder("1", StatusCompleted, 30), testNow.Add(-2*time.Hour), 0)
+	hub.recordOrder(testOrder("2", StatusPending, 10), testNow, 0)
+
+	stats := hub.generateStats()
+	if stats.StatsWindow != "1h0m0s" {
+		t.Errorf("stats_window = %q, want 1h0m0s", stats.StatsWindow)
+	}
+	// The average stays within the window; the rest counts both orders
+	if stats.TotalOrders != 1 || stats.TotalRevenue != 10 || stats.AverageOrder != 10 {
+		t.Errorf("windowed totals = %d orders, %v revenue, %v average; want 1, 10, 10", stats.TotalOrders, stats.TotalRevenue, stats.AverageOrder)
+	}
+	if stats.LifetimeOrders != 2 || stats.StatusCounts["completed"] != 1 || stats.StatusCounts["pending"] != 1 || stats.TotalRevenueBase != 40 {
+		t.Errorf("lifetime figures = %d orders, %v, %v base revenue; want both orders", stats.LifetimeOrders, stats.StatusCounts, stats.TotalRevenueBase)
+	}
+
+	hub, _ = newTestHub(t, "--stats-window", "0")
+	data, err := json.Marshal(hub.generateStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "stats_window") {
+		t.Errorf("stats %s name a window although the totals cover all time", data)
+	}
+}
+
+func TestEmptyAggregateStats(t *testing.T) {
+	hub, _ := newTestHub(t)
+
+	stats := hub.generateStats()
+	if stats.TotalOrders != 0 || stats.AverageOrder != 0 || stats.ErrorRate != 0 {
+		t.Errorf("got %d orders averaging %v with error rate %v, want all 0", stats.TotalOrders, stats.AverageOrder, stats.ErrorRate)
+	}
+	data, err := hub.statsMessage()
+	if err != nil {
+		t.Fatalf("statsMessage: %v", err)
+	}
+	if !json.Valid(data) || strings.Contains(string(data), "NaN") {
+		t.Errorf("stats message %s is not valid JSON", data)
+	}
+}
+
+func TestStatsSanitize(t *testing.T) {
+	tests := []struct {
+		name        string
+		stats       Stats
+		wantAverage float64
+		wantRate    float64
+	}{
+		{name: "no orders", stats: Stats{TotalRevenue: 10, AverageOrder: math.NaN(), ErrorRate: math.NaN()}},
+		{name: "infinite revenue", stats: Stats{TotalOrders: 2, TotalRevenue: math.Inf(1), AverageOrder: math.Inf(1)}},
+		{name: "average recomputed", stats: Stats{TotalOrders: 4, TotalRevenue: 10, ErrorRate: 0.25}, wantAverage: 2.5, wantRate: 0.25},
+		{name: "error rate above 1", stats: Stats{ErrorRate: 1.5}, wantRate: 1},
+		{name: "negative error rate", stats: Stats{ErrorRate: -0.2}},
+		{name: "infinite error rate", stats: Stats{ErrorRate: math.Inf(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := tt.stats
+			stats.sanitize()
+			if stats.AverageOrder != tt.wantAverage || stats.ErrorRate != tt.wantRate {
+				t.Errorf("average %v, error rate %v; want %v and %v", stats.AverageOrder, stats.ErrorRate, tt.wantAverage, tt.wantRate)
+			}
+			if _, err := json.Marshal(stats); err != nil {
+				t.Errorf("marshal: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandlePolledStats(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.recordOrder(testOrder("1", StatusCompleted, 42), testNow, 0)
+
+	rec := httptest.NewRecorder()
+	handlePolledStats(hub, rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	var polled PolledStats
+	decodeJSON(t, rec, &polled)
+	if polled.TotalOrders != 1 || polled.TotalRevenue != 42 {
+		t.Errorf("stats = %+v, want one order of 42", polled.Stats)
+	}
+	if !polled.ServerTime.Equal(testNow) {
+		t.Errorf("server_time = %v, want %v", polled.ServerTime, testNow)
+	}
+
+	rec = httptest.NewRecorder()
+	handlePolledStats(hub, rec, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var health Health
+	decodeJSON(t, rec, &health)
+	if health.Status != "ok" {
+		t.Errorf("status = %q, want ok", health.Status)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	hub, mr := newTestHub(t)
+
+	rec := httptest.NewRecorder()
+	handleReadyz(hub, rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("with Redis up, status = %d, want 200", rec.Code)
+	}
+
+	mr.Close()
+	rec = httptest.NewRecorder()
+	handleReadyz(hub, rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("with Redis down, status = %d, want 503", rec.Code)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	hub, _ := newTestHub(t)
+	if err := hub.pingRedis(context.Background()); err != nil {
+		t.Fatalf("Ping before Close: %v", err)
+	}
+
+	if err := hub.Close(); err != nil {
+		t.Fatalf("first Close: %v", err)
+	}
+	if err := hub.Close(); err != nil {
+		t.Errorf("second Close: %v, want the first call's nil result", err)
+	}
+	if err := hub.redis.Ping(context.Background()).Err(); err != redis.ErrClosed {
+		t.Errorf("Ping after Close = %v, want %v", err, redis.ErrClosed)
+	}
+}
+
+func TestConcurrentConnects(t *testing.T) {
+	const clients = 100
+
+	for _, buffer := range []string{"0", "256"} {
+		t.Run("buffer "+buffer, func(t *testing.T) {
+			hub, _ := newTestHub(t, "--hub-channel-buffer", buffer, "--ws-drain-grace", "0s")
+			url := serveTestHub(t, hub) + "/ws"
+
+			conns := make([]*websocket.Conn, clients)
+			var wg sync.WaitGroup
+			start := time.Now()
+			for i := range conns {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+					if err != nil {
+						t.Errorf("dial %d: %v", i, err)
+						return
+					}
+					conns[i] = conn
+				}(i)
+			}
+			wg.Wait()
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("%d concurrent connects took %s", clients, elapsed)
+			}
+			waitFor(t, "every client to register", func() bool { return hub.ConnectionCount() == clients })
+
+			for _, conn := range conns {
+				if conn != nil {
+					conn.Close()
+				}
+			}
+			waitFor(t, "every client to unregister", func() bool { return hub.ConnectionCount() == 0 })
+		})
+	}
+}
+
+func TestConcurrentStatsComputeOnce(t *testing.T) {
+	const callers = 50
+
+	hub, _ := newTestHub(t)
+	hub.recordOrder(testOrder("1", StatusCompleted, 10), testNow, 0)
+	before := histogramCount(t, statsComputationDuration.histogram())
+
+	// Hold the aggregate so the first computation blocks while the other
+	// callers pile up behind it
+	hub.aggregate.mu.Lock()
+	var started, done sync.WaitGroup
+	results := make([]Stats, callers)
+	for i := range results {
+		started.Add(1)
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			started.Done()
+			results[i] = hub.currentStats()
+		}(i)
+	}
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	hub.aggregate.mu.Unlock()
+	done.Wait()
+
+	if n := histogramCount(t, statsComputationDuration.histogram()) - before; n != 1 {
+		t.Errorf("%d concurrent requests computed stats %d times, want once", callers, n)
+	}
+	for i, stats := range results {
+		if stats.TotalOrders != 1 {
+			t.Errorf("caller %d got total_orders = %d, want 1", i, stats.TotalOrders)
+		}
+	}
+
+	// Later requests compute afresh
+	hub.currentStats()
+	if n := histogramCount(t, statsComputationDuration.histogram()) - before; n != 2 {
+		t.Errorf("after a sequential request computed %d times, want 2", n)
+	}
+}
+
+func TestCompressedConnectionsGauge(t *testing.T) {
+	enabled := upgrader.EnableCompression
+	upgrader.EnableCompression = true
+	t.Cleanup(func() { upgrader.EnableCompression = enabled })
+
+	hub, _ := newTestHub(t)
+	url := serveTestHub(t, hub) + "/ws"
+	before := testutil.ToFloat64(websocketCompressedConnections)
+	compressed := func() float64 { return testutil.ToFloat64(websocketCompressedConnections) - before }
+
+	deflate := &websocket.Dialer{EnableCompression: true}
+	var conns []*websocket.Conn
+	for _, dialer := range []*websocket.Dialer{deflate, deflate, websocket.DefaultDialer} {
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conns = append(conns, conn)
+	}
+	waitFor(t, "every client to register", func() bool { return hub.ConnectionCount() == 3 })
+	if got := compressed(); got != 2 {
+		t.Errorf("websocket_compressed_connections = %v, want the 2 compressing clients", got)
+	}
+
+	conns[0].Close()
+	conns[2].Close()
+	waitFor(t, "two clients to unregister", func() bool { return hub.ConnectionCount() == 1 })
+	if got := compressed(); got != 1 {
+		t.Errorf("after one compressing client left websocket_compressed_connections = %v, want 1", got)
+	}
+
+	conns[1].Close()
+	waitFor(t, "the last client to unregister", func() bool { return hub.ConnectionCount() == 0 })
+	if got := compressed(); got != 0 {
+		t.Errorf("with no clients websocket_compressed_connections = %v, want 0", got)
+	}
+}
+
+func TestStatsComputationObserved(t *testing.T) {
+	hub, _ := newTestHub(t)
+	before := histogramCount(t, statsComputationDuration.histogram())
+
+	for i := 1; i <= 3; i++ {
+		hub.currentStats()
+		if n := histogramCount(t, statsComputationDuration.histogram()) - before; n != uint64(i) {
+			t.Fatalf("after %d computations the histogram has %d observations", i, n)
+		}
+	}
+}
+
+func TestSlowStatsComputationLogged(t *testing.T) {
+	for _, tt := range []struct {
+		threshold string
+		wantLog   bool
+	}{
+		{threshold: "1ns", wantLog: true},
+		{threshold: "1h", wantLog: false},
+		{threshold: "0s", wantLog: false},
+	} {
+		t.Run(tt.threshold, func(t *testing.T) {
+			hub, _ := newTestHub(t, "--slow-stats-threshold", tt.threshold)
+			var logged strings.Builder
+			log.SetOutput(&logged)
+			t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+			hub.currentStats()
+			if got := strings.Contains(logged.String(), "Slow stats computation"); got != tt.wantLog {
+				t.Errorf("logged a warning = %t, want %t; log: %q", got, tt.wantLog, logged.String())
+			}
+		})
+	}
+}
+
+func TestAdminReadsDuringChurn(t *testing.T) {
+	hub, _ := newTestHub(t, "--ws-drain-grace", "0s")
+	url := serveTestHub(t, hub) + "/ws"
+	stop := make(chan struct{})
+
+	// Clients connect and leave continuously
+	var churn sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		churn.Add(1)
+		go func() {
+			defer churn.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.Close()
+			}
+		}()
+	}
+
+	// While the admin endpoints read the registered clients
+	waitFor(t, "the churn to start", func() bool { return hub.ConnectionCount() > 0 })
+	var readers sync.WaitGroup
+	for _, path := range []string{"/connections", "/connections?verbose=true", "/api/overview"} {
+		readers.Add(1)
+		go func(path string) {
+			defer readers.Done()
+			for i := 0; i < 200; i++ {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if strings.HasPrefix(path, "/connections") {
+					handleConnections(hub, rec, req)
+				} else {
+					handleOverview(hub, rec, req)
+				}
+				if rec.Code != http.StatusOK {
+					t.Errorf("GET %s: status = %d, want 200", path, rec.Code)
+				}
+				hub.ConnectionCount()
+			}
+		}(path)
+	}
+	readers.Wait()
+	close(stop)
+	churn.Wait()
+
+	waitFor(t, "every client to unregister", func() bool { return hub.ConnectionCount() == 0 })
+	rec := httptest.NewRecorder()
+	handleConnections(hub, rec, httptest.NewRequest(http.MethodGet, "/connections?verbose=true", nil))
+	var conns Connections
+	decodeJSON(t, rec, &conns)
+	if conns.Count != 0 || len(conns.RemoteAddrs) != 0 {
+		t.Errorf("after the churn /connections = %+v, want none", conns)
+	}
+}
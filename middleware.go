@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultSecurityHeaders are set on every response unless overridden. The CSP
+// allows the dashboard's inline script and its WebSocket back to the server.
+var defaultSecurityHeaders = []headerOverride{
+	{name: "X-Content-Type-Options", value: "nosniff"},
+	{name: "X-Frame-Options", value: "DENY"},
+	{name: "Referrer-Policy", value: "no-referrer"},
+	{name: "Content-Security-Policy", value: "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; connect-src 'self' ws: wss:; frame-ancestors 'none'"},
+}
+
+// headerOverride replaces a response header; an empty value removes it
+type headerOverride struct {
+	name  string
+	value string
+}
+
+// headerOverrides is a repeatable flag of "Name: value" entries. A single
+// value may carry several entries separated by "|".
+type headerOverrides []headerOverride
+
+func (h *headerOverrides) String() string {
+	parts := make([]string, 0, len(*h))
+	for _, o := range *h {
+		parts = append(parts, o.name+": "+o.value)
+	}
+	return strings.Join(parts, "|")
+}
+
+func (h *headerOverrides) Set(value string) error {
+	for _, entry := range strings.Split(value, "|") {
+		name, val, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("header %q is not of the form Name: value", entry)
+		}
+		*h = append(*h, headerOverride{
+			name:  http.CanonicalHeaderKey(name),
+			value: strings.TrimSpace(val),
+		})
+	}
+	return nil
+}
+
+// securityHeaders applies the overrides to the defaults.
+func securityHeaders(overrides headerOverrides) http.Header {
+	headers := make(http.Header)
+	for _, o := range append(append([]headerOverride(nil), defaultSecurityHeaders...), overrides...) {
+		if o.value == "" {
+			headers.Del(o.name)
+			continue
+		}
+		headers.Set(o.name, o.value)
+	}
+	return headers
+}
+
+// withSecurityHeaders sets the given headers on every response.
+func withSecurityHeaders(headers http.Header, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range headers {
+			w.Header()[name] = values
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authToken is the bearer token protected endpoints require. Empty leaves
+// them open.
+var authToken string
+
+// requireAuth rejects requests without a matching bearer token when an auth
+// token is configured. Browsers cannot set headers on WebSocket handshakes,
+// so those may pass the token as a token query parameter instead.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authToken == "" {
+			next(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok && websocket.IsWebSocketUpgrade(r) {
+			token = r.URL.Query().Get("token")
+			ok = token != ""
+		}
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(authToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// CORS settings for the REST API. Only the bearer token is allowed as a
+// credential; no cookies are involved, so credentials mode is never needed.
+const (
+	corsAllowMethods  = "GET, POST, PUT, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type"
+	corsExposeHeaders = "Retry-After"
+	corsMaxAge        = "600"
+)
+
+// corsOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, empty when the origin is not allowed. It follows the
+// allowed-origins list the WebSocket uses; while an auth token is set, "*"
+// is not honored and only listed origins may call the API.
+func corsOrigin(origin string) string {
+	origin = strings.TrimSuffix(origin, "/")
+	for _, allowed := range allowedOrigins {
+		switch {
+		case allowed == "*" && authToken == "":
+			return "*"
+		case strings.EqualFold(allowed, origin):
+			return origin
+		}
+	}
+	return ""
+}
+
+// withCORS lets the allowed origins call a REST endpoint from the browser
+// and answers their preflight requests. Same-origin requests pass through
+// untouched.
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allow := corsOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if allow == "" {
+			if preflight {
+				writeError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			next(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allow)
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			next(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveDashboard fetches the dashboard through the security header middleware
+// as configured by args.
+func serveDashboard(t *testing.T, args ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	cfg, err := loadConfig(args)
+	if err != nil {
+		t.Fatalf("loadConfig(%q): %v", args, err)
+	}
+	handler := withSecurityHeaders(securityHeaders(cfg.SecurityHeaders), http.HandlerFunc(handleDashboard))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	return rec
+}
+
+func TestDashboardSecurityHeaders(t *testing.T) {
+	rec := serveDashboard(t)
+
+	for _, h := range defaultSecurityHeaders {
+		if got := rec.Header().Get(h.name); got != h.value {
+			t.Errorf("%s = %q, want %q", h.name, got, h.value)
+		}
+	}
+
+	// The dashboard's inline script opens a WebSocket back to the server
+	csp := rec.Header().Get("Content-Security-Policy")
+	for _, directive := range []string{"'unsafe-inline'", "connect-src 'self' ws: wss:"} {
+		if !strings.Contains(csp, directive) {
+			t.Errorf("Content-Security-Policy %q lacks %s", csp, directive)
+		}
+	}
+	if !strings.Contains(rec.Body.String(), "new WebSocket(") {
+		t.Error("dashboard no longer opens a WebSocket; revisit the CSP")
+	}
+}
+
+func TestDashboardSecurityHeaderOverrides(t *testing.T) {
+	rec := serveDashboard(t,
+		"--security-header", "X-Frame-Options: SAMEORIGIN",
+		"--security-header", "Referrer-Policy:|permissions-policy: camera=()",
+	)
+
+	if got := rec.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options = %q, want the override SAMEORIGIN", got)
+	}
+	if _, ok := rec.Header()["Referrer-Policy"]; ok {
+		t.Errorf("Referrer-Policy = %q, want it removed", rec.Header().Get("Referrer-Policy"))
+	}
+	if got := rec.Header().Get("Permissions-Policy"); got != "camera=()" {
+		t.Errorf("Permissions-Policy = %q, want camera=()", got)
+	}
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want the default kept", got)
+	}
+}
+
+func TestHeaderOverridesRejectsMalformed(t *testing.T) {
+	for _, value := range []string{"X-Frame-Options", ": DENY", "X-Frame-Options: DENY|nonsense"} {
+		var overrides headerOverrides
+		if err := overrides.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// OrderStatus is the lifecycle state of an order
+type OrderStatus string
+
+// The statuses the monitor understands
+const (
+	StatusPending    OrderStatus = "pending"
+	StatusProcessing OrderStatus = "processing"
+	StatusCompleted  OrderStatus = "completed"
+	StatusFailed     OrderStatus = "failed"
+)
+
+// orderStatuses lists the known statuses
+var orderStatuses = []OrderStatus{StatusPending, StatusProcessing, StatusCompleted, StatusFailed}
+
+// ParseStatus normalizes case and surrounding space and returns the known
+// status s names.
+func ParseStatus(s string) (OrderStatus, error) {
+	status := OrderStatus(strings.ToLower(strings.TrimSpace(s)))
+	for _, known := range orderStatuses {
+		if status == known {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("status must be one of %v, got %q", orderStatuses, s)
+}
+
+// UnmarshalJSON rejects unknown statuses, so a typo never reaches the
+// metrics as a label value of its own.
+func (s *OrderStatus) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	status, err := ParseStatus(raw)
+	if err != nil {
+		return err
+	}
+	*s = status
+	return nil
+}
+
+// decodeOrderAnyStatus decodes an order while keeping an unknown status as
+// sent, for the sources the unknown-status policy applies to.
+func decodeOrderAnyStatus(data []byte) (Order, error) {
+	var raw struct {
+		Order
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Order{}, err
+	}
+	raw.Order.Status = OrderStatus(raw.Status)
+	return raw.Order, nil
+}
+
+// Policies for orders arriving with a status outside orderStatuses
+const (
+	unknownStatusReject      = "reject"
+	unknownStatusPassthrough = "passthrough"
+	unknownStatusMap         = "map"
+)
+
+// Policies for orders older than the maximum accepted age
+const (
+	staleOrderReject = "reject"
+	staleOrderFlag   = "flag"
+)
+
+// orderIDPrefix is random per process, so IDs from different instances or
+// restarts never collide
+var orderIDPrefix = func() string {
+	b := make([]byte, 6)
+	if _, err := crand.Read(b); err != nil {
+		panic(fmt.Sprintf("generating order ID prefix: %v", err))
+	}
+	return hex.EncodeToString(b)
+}()
+
+var orderIDSeq atomic.Uint64
+
+// newOrderID returns a unique order ID: the process prefix and a counter.
+func newOrderID() string {
+	return fmt.Sprintf("order_%s_%d", orderIDPrefix, orderIDSeq.Add(1))
+}
+
+// applyStatusPolicy resolves an unknown status according to the configured
+// policy. It reports false when the order must be dropped.
+func (h *Hub) applyStatusPolicy(order *Order) bool {
+	if status, err := ParseStatus(string(order.Status)); err == nil {
+		order.Status = status
+		return true
+	}
+	unknownStatusOrders.WithLabelValues(h.unknownStatusPolicy).Inc()
+
+	switch h.unknownStatusPolicy {
+	case unknownStatusPassthrough:
+		return true
+	case unknownStatusMap:
+		order.Status = h.unknownStatusDefault
+		return true
+	default:
+		log.Printf("Dropping order %s with unknown status %q", order.ID, order.Status)
+		err := fmt.Errorf("unknown status %q", order.Status)
+		h.reportProcessingError(errorValidation, order.ID, err)
+		h.deadLetterOrder(errorValidation, *order, err)
+		return false
+	}
+}
+
+// applyAgePolicy handles orders whose timestamp is older than the maximum
+// accepted age, which would otherwise pollute the time-windowed stats. It
+// reports false when the order must be dropped.
+func (h *Hub) applyAgePolicy(order *Order) bool {
+	if h.maxOrderAge <= 0 || h.now().Sub(order.Timestamp) <= h.maxOrderAge {
+		return true
+	}
+	ordersTooOld.Inc()
+
+	if h.staleOrderPolicy == staleOrderFlag {
+		order.Stale = true
+		return true
+	}
+	log.Printf("Dropping order %s older than %s", order.ID, h.maxOrderAge)
+	err := fmt.Errorf("older than the maximum order age of %s", h.maxOrderAge)
+	h.reportProcessingError(errorValidation, order.ID, err)
+	h.deadLetterOrder(errorValidation, *order, err)
+	return false
+}
+
+// recordOrder folds a processed order into the metrics and aggregates. at is
+// when processing finished and latency how long it took, zero when unknown.
+// It reports whether the order was accepted; duplicates are not. The dedup
+// check runs after the policies, so a rejected order doesn't mark its ID as
+// seen and a corrected retry is still recorded.
+func (h *Hub) recordOrder(order Order, at time.Time, latency time.Duration) bool {
+	// Before anything can store, broadcast or label the customer
+	h.anonymizer.anonymize(&order)
+	if !h.applyStatusPolicy(&order) || !h.applyAgePolicy(&order) || h.duplicate(order) {
+		return false
+	}
+	order.ProcessedBy = h.nodeID
+
+	ordersTotal.WithLabelValues(statusLabel(order.Status), h.nodeID, order.Tenant).Inc()
+	orderAmount.Observe(order.Amount)
+	var revenue float64
+	if countsAsRevenue(order) {
+		revenue = order.Amount
+		revenueTotal.WithLabelValues(order.Tenant).Add(revenue)
+	}
+	if latency > 0 {
+		h.latencies.add(at, latency)
+		if h.sampleLatency() {
+			orderLatency.WithLabelValues(statusLabel(order.Status)).Observe(latency.Seconds())
+		}
+	}
+	h.orderCounts.inc()
+	h.orders.add(order)
+	h.aggregate.add(order)
+	if h.window != nil {
+		h.window.add(at, revenue)
+	}
+	h.revenueSeries.add(at, revenue)
+	h.outcomes.add(at, order.Status == StatusFailed)
+	h.revenue.add(order)
+	h.recordCustomerRevenue(order)
+	h.history.record(order)
+	h.sla.observe(order, at)
+	h.notifyHighValue(order)
+	h.publishOrderEvent(order)
+	h.markUpdated(at)
+	h.markStatsChanged()
+	return true
+}
+
+// countsAsRevenue reports whether the order's amount goes into revenue:
+// every revenue figure, from the counter to the per-currency totals, leaves
+// out failed orders. Non-positive amounts are left out too, since counters
+// panic on negative additions and Redis orders are not validated.
+func countsAsRevenue(order Order) bool {
+	return order.Status != StatusFailed && order.Amount > 0
+}
+
+// sampleLatency decides whether this order's latency is observed into the
+// histogram, per the configured sample rate.
+func (h *Hub) sampleLatency() bool {
+	return h.latencySampleRate >= 1 || h.sampleRand.Float64() < h.latencySampleRate
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUnknownStatusPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantRecorded bool
+		wantStatus   OrderStatus
+		wantCounted  string
+	}{
+		{
+			name: "reject",
+			args: []string{"--unknown-status-policy", "reject"},
+		},
+		{
+			name:         "passthrough",
+			args:         []string{"--unknown-status-policy", "passthrough"},
+			wantRecorded: true,
+			wantStatus:   "refunded",
+			wantCounted:  otherStatus,
+		},
+		{
+			name:         "map to the default",
+			args:         []string{"--unknown-status-policy", "map"},
+			wantRecorded: true,
+			wantStatus:   StatusProcessing,
+			wantCounted:  string(StatusProcessing),
+		},
+		{
+			name:         "map to a configured status",
+			args:         []string{"--unknown-status-policy", "map", "--unknown-status-default", "completed"},
+			wantRecorded: true,
+			wantStatus:   StatusCompleted,
+			wantCounted:  string(StatusCompleted),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, mr := newTestHub(t, tt.args...)
+			hub.history.ping()
+			policy := hub.unknownStatusPolicy
+			before := testutil.ToFloat64(unknownStatusOrders.WithLabelValues(policy))
+
+			recorded := hub.recordOrder(testOrder("1", "refunded", 10), testNow, 0)
+			if recorded != tt.wantRecorded {
+				t.Fatalf("recorded = %t, want %t", recorded, tt.wantRecorded)
+			}
+			if got := testutil.ToFloat64(unknownStatusOrders.WithLabelValues(policy)) - before; got != 1 {
+				t.Errorf("orders_unknown_status_total{policy=%q} rose by %v, want 1", policy, got)
+			}
+
+			stats := hub.generateStats()
+			if !tt.wantRecorded {
+				if stats.TotalOrders != 0 {
+					t.Errorf("total_orders = %d, want the order dropped", stats.TotalOrders)
+				}
+				if letters, _ := mr.List(deadLetterKey); len(letters) != 1 {
+					t.Errorf("dead letters = %q, want the rejected order", letters)
+				}
+				return
+			}
+			if stats.StatusCounts[tt.wantCounted] != 1 {
+				t.Errorf("status_counts = %v, want the order under %s", stats.StatusCounts, tt.wantCounted)
+			}
+			if orders := hub.orders.snapshot(); len(orders) != 1 || orders[0].Status != tt.wantStatus {
+				t.Errorf("buffered orders = %+v, want one with status %s", orders, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestKnownStatusIgnoresPolicy(t *testing.T) {
+	hub, _ := newTestHub(t, "--unknown-status-policy", "map", "--unknown-status-default", "failed")
+	before := testutil.ToFloat64(unknownStatusOrders.WithLabelValues(unknownStatusMap))
+
+	if !hub.recordOrder(testOrder("1", "Completed", 10), testNow, 0) {
+		t.Fatal("order with a known status not recorded")
+	}
+	if orders := hub.orders.snapshot(); orders[0].Status != StatusCompleted {
+		t.Errorf("status = %q, want it normalized to completed", orders[0].Status)
+	}
+	if got := testutil.ToFloat64(unknownStatusOrders.WithLabelValues(unknownStatusMap)) - before; got != 0 {
+		t.Errorf("known status counted as unknown %v times", got)
+	}
+}
+
+func TestLatencySampleRate(t *testing.T) {
+	const orders = 20
+
+	for _, tt := range []struct {
+		rate             string
+		wantObservations uint64
+	}{
+		{rate: "0", wantObservations: 0},
+		{rate: "1", wantObservations: orders},
+	} {
+		t.Run(tt.rate, func(t *testing.T) {
+			hub, _ := newTestHub(t, "--latency-sample-rate", tt.rate)
+			latency := orderLatency.WithLabelValues(string(StatusCompleted)).(prometheus.Histogram)
+			counted := ordersTotal.WithLabelValues(string(StatusCompleted), hub.nodeID, "orders")
+			observedBefore := histogramCount(t, latency)
+			countedBefore := testutil.ToFloat64(counted)
+
+			for i := 0; i < orders; i++ {
+				hub.recordOrder(testOrder(fmt.Sprint(i), StatusCompleted, 10), testNow, 50*time.Millisecond)
+			}
+			if got := histogramCount(t, latency) - observedBefore; got != tt.wantObservations {
+				t.Errorf("latency observations = %d, want %d", got, tt.wantObservations)
+			}
+			if got := testutil.ToFloat64(counted) - countedBefore; got != orders {
+				t.Errorf("orders_total rose by %v, want every one of the %d orders", got, orders)
+			}
+		})
+	}
+}
+
+func TestNodeIDStampedOnOrders(t *testing.T) {
+	hub, _ := newTestHub(t, "--node-id", "node-a")
+	hub.history.ping()
+	counted := ordersTotal.WithLabelValues(string(StatusPending), "node-a", "orders")
+	before := testutil.ToFloat64(counted)
+
+	// Orders relayed from another instance carry its ID until processed here
+	order := testOrder("1", StatusPending, 10)
+	order.ProcessedBy = "node-b"
+	hub.recordOrder(order, testNow, 0)
+	hub.history.persist(<-hub.history.pending)
+
+	if got := hub.orders.snapshot()[0].ProcessedBy; got != "node-a" {
+		t.Errorf("buffered order processed_by = %q, want node-a", got)
+	}
+	if got := testutil.ToFloat64(counted) - before; got != 1 {
+		t.Errorf("orders_total{node=\"node-a\"} rose by %v, want 1", got)
+	}
+
+	rec := httptest.NewRecorder()
+	handleGetOrder(hub, rec, httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /orders/1 status = %d, want 200", rec.Code)
+	}
+	var stored Order
+	decodeJSON(t, rec, &stored)
+	if stored.ProcessedBy != "node-a" {
+		t.Errorf("stored order processed_by = %q, want node-a", stored.ProcessedBy)
+	}
+}
+
+func TestNodeIDDefaultsToHostname(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname:", err)
+	}
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NodeID != host {
+		t.Errorf("node ID = %q, want the hostname %q", cfg.NodeID, host)
+	}
+}
+
+func TestMaxOrderAgePolicies(t *testing.T) {
+	tests := []struct {
+		policy       string
+		wantRecorded bool
+	}{
+		{policy: staleOrderReject},
+		{policy: staleOrderFlag, wantRecorded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			hub, mr := newTestHub(t, "--max-order-age", "1h", "--stale-order-policy", tt.policy)
+			hub.history.ping()
+			now := testNow
+			hub.now = func() time.Time { return now }
+			before := testutil.ToFloat64(ordersTooOld)
+
+			// Exactly the maximum age is still fresh
+			now = testNow.Add(time.Hour)
+			if !hub.recordOrder(testOrder("fresh", StatusPending, 10), now, 0) {
+				t.Fatal("order at the maximum age not recorded")
+			}
+			now = testNow.Add(time.Hour + time.Second)
+			recorded := hub.recordOrder(testOrder("stale", StatusPending, 10), now, 0)
+			if recorded != tt.wantRecorded {
+				t.Fatalf("stale order recorded = %t, want %t", recorded, tt.wantRecorded)
+			}
+			if got := testutil.ToFloat64(ordersTooOld) - before; got != 1 {
+				t.Errorf("orders_too_old_total rose by %v, want 1", got)
+			}
+
+			orders := hub.orders.snapshot()
+			if orders[0].ID != "fresh" || orders[0].Stale {
+				t.Errorf("fresh order = %+v, want it unflagged", orders[0])
+			}
+			if !tt.wantRecorded {
+				if len(orders) != 1 {
+					t.Errorf("buffered %d orders, want the stale one dropped", len(orders))
+				}
+				if letters, _ := mr.List(deadLetterKey); len(letters) != 1 {
+					t.Errorf("dead letters = %q, want the stale order", letters)
+				}
+				return
+			}
+			if len(orders) != 2 || orders[1].ID != "stale" || !orders[1].Stale {
+				t.Errorf("buffered orders = %+v, want the stale one flagged", orders)
+			}
+		})
+	}
+}
+
+func TestMaxOrderAgeDisabled(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.now = func() time.Time { return testNow.AddDate(1, 0, 0) }
+
+	if !hub.recordOrder(testOrder("old", StatusPending, 10), testNow, 0) {
+		t.Fatal("year-old order rejected without a maximum age")
+	}
+	if hub.orders.snapshot()[0].Stale {
+		t.Error("order flagged stale without a maximum age")
+	}
+}
+
+func TestNewOrderIDUnique(t *testing.T) {
+	const (
+		goroutines = 10
+		perRoutine = 100
+	)
+
+	ids := make(chan string, goroutines*perRoutine)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perRoutine; j++ {
+				ids <- newOrderID()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool, goroutines*perRoutine)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("duplicate order ID %s", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != goroutines*perRoutine {
+		t.Errorf("generated %d IDs, want %d", len(seen), goroutines*perRoutine)
+	}
+}
+
+func TestSubmitOrderAssignsID(t *testing.T) {
+	hub, _ := newTestHub(t)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"status":"completed","amount":10}`)
+		handleSubmitOrder(hub, rec, httptest.NewRequest(http.MethodPost, "/orders", body))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+		}
+		var order Order
+		decodeJSON(t, rec, &order)
+		ids = append(ids, order.ID)
+	}
+	if !strings.HasPrefix(ids[0], "order_") || ids[0] == ids[1] {
+		t.Errorf("assigned IDs %q, want two distinct order_ IDs", ids)
+	}
+}
+
+func TestRevenueFiguresAgree(t *testing.T) {
+	for _, window := range []string{"1h", "0"} {
+		t.Run("window "+window, func(t *testing.T) {
+			hub, _ := newTestHub(t, "--stats-window", window)
+			counted := revenueTotal.WithLabelValues("orders")
+			before := testutil.ToFloat64(counted)
+
+			hub.recordOrder(testOrder("1", StatusCompleted, 100), testNow, 0)
+			hub.recordOrder(testOrder("2", StatusPending, 20), testNow, 0)
+			hub.recordOrder(testOrder("3", StatusFailed, 50), testNow, 0)
+			hub.recordOrder(testOrder("4", StatusCompleted, -30), testNow, 0)
+
+			const want = 120
+			stats := hub.generateStats()
+			var byCurrency, series float64
+			for _, revenue := range stats.RevenueByCurrency {
+				byCurrency += revenue.Amount
+			}
+			for _, point := range hub.revenueSeries.series(testNow, time.Minute, 1) {
+				series += point.Revenue
+			}
+			for _, tt := range []struct {
+				name string
+				got  float64
+			}{
+				{"revenue_total", testutil.ToFloat64(counted) - before},
+				{"total_revenue", stats.TotalRevenue},
+				{"total_revenue_base", stats.TotalRevenueBase},
+				{"revenue_by_currency", byCurrency},
+				{"lifetime aggregate", hub.aggregate.revenue},
+				{"revenue series", series},
+			} {
+				if tt.got != want {
+					t.Errorf("%s = %v, want %v without the failed and negative orders", tt.name, tt.got, want)
+				}
+			}
+			if stats.AverageOrder != want/4.0 {
+				t.Errorf("average_order = %v, want %v over all 4 orders", stats.AverageOrder, want/4.0)
+			}
+		})
+	}
+}
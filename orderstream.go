@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// eventOrder carries a single order: live on /ws/orders, or replayed.
+const eventOrder = "order"
+
+// Streams a WebSocket client can connect to. The zero value is /ws: stats,
+// alerts and order events such as high-value orders.
+const (
+	streamStats  = ""
+	streamOrders = "orders"
+)
+
+// publishOrderEvent streams a recorded order to the /ws/orders clients. It
+// is skipped when there are none, so recording doesn't pay for a broadcast
+// per order nobody reads.
+func (h *Hub) publishOrderEvent(order Order) {
+	if h.orderStreamClients.Load() == 0 {
+		return
+	}
+	seq := h.orderSeq.Add(1)
+	data, err := newSequencedEnvelope(eventOrder, seq, order)
+	if err != nil {
+		log.Printf("Order event encode error: %v", err)
+		return
+	}
+	h.publish(message{data: data, seq: seq, status: order.Status, region: order.Region, stream: streamOrders})
+}
+
+// handleOrderStream serves /ws/orders: every recorded order as an order
+// envelope, with the same status filter and room as /ws, and nothing else.
+func handleOrderStream(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	serveWebSocket(hub, streamOrders, w, r)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD)"
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// startedAt is when the process started, for the reported uptime
+var startedAt = time.Now()
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// Overview is the response of /api/overview: the live stats and the state of
+// the process in one object, for status pages that don't parse Prometheus
+type Overview struct {
+	Stats         Stats     `json:"stats"`
+	Connections   int       `json:"connections"`
+	RedisHealthy  bool      `json:"redis_healthy"`
+	Paused        bool      `json:"paused"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	Build         BuildInfo `json:"build"`
+}
+
+// handleOverview serves the overview. Redis connectivity is the history
+// store's health, which a write or a background ping confirms at least every
+// historyRecheckInterval, rather than a fresh ping, so a call costs no more
+// than /api/stats.
+func handleOverview(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, Overview{
+		Stats:         hub.currentStats(),
+		Connections:   hub.ConnectionCount(),
+		RedisHealthy:  hub.history.healthy.Load(),
+		Paused:        hub.paused.Load(),
+		UptimeSeconds: time.Since(startedAt).Seconds(),
+		Build:         BuildInfo{Version: version, Commit: commit},
+	})
+}
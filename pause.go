@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// eventPaused reports broadcasting being paused or resumed.
+const eventPaused = "paused"
+
+// PauseState is the response of /admin/pause and /admin/resume, and the
+// payload of the paused envelope
+type PauseState struct {
+	Paused bool `json:"paused"`
+}
+
+// setPaused pauses or resumes broadcasting. While paused, orders are still
+// recorded and clients stay connected, but run() drops every broadcast
+// except the paused notices themselves.
+func (h *Hub) setPaused(paused bool) {
+	h.pauseMu.Lock()
+	defer h.pauseMu.Unlock()
+
+	if h.paused.Swap(paused) == paused {
+		return
+	}
+	if paused {
+		broadcastPaused.Set(1)
+		log.Println("Broadcasting paused")
+	} else {
+		broadcastPaused.Set(0)
+		log.Println("Broadcasting resumed")
+		// Catch clients up rather than waiting for the next order
+		defer h.markStatsChanged()
+	}
+	notice, err := newEnvelope(eventPaused, PauseState{Paused: paused})
+	if err != nil {
+		log.Printf("Pause notice encode error: %v", err)
+		return
+	}
+	h.publish(message{data: notice, unpausable: true})
+}
+
+// handleSetPaused serves POST /admin/pause and /admin/resume, answering
+// with the resulting state.
+func handleSetPaused(hub *Hub, paused bool, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	hub.setPaused(paused)
+	writeJSON(w, http.StatusOK, PauseState{Paused: hub.paused.Load()})
+}
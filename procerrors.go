@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Reasons an incoming order fails to process, the only values of the
+// reason label
+const (
+	errorUnmarshal  = "unmarshal"
+	errorValidation = "validation"
+	errorPublish    = "publish"
+)
+
+// eventError carries a processing error.
+const eventError = "error"
+
+// ProcessingError is broadcast when an incoming order cannot be processed
+type ProcessingError struct {
+	Reason  string    `json:"reason"`
+	OrderID string    `json:"order_id,omitempty"`
+	Error   string    `json:"error"`
+	At      time.Time `json:"at"`
+}
+
+// reportProcessingError counts a failed order under its reason and streams
+// it to clients for the dashboard's error feed. orderID is empty when the
+// order could not be decoded.
+func (h *Hub) reportProcessingError(reason, orderID string, err error) {
+	processingErrors.WithLabelValues(reason).Inc()
+
+	data, encErr := newEnvelope(eventError, ProcessingError{
+		Reason:  reason,
+		OrderID: orderID,
+		Error:   err.Error(),
+		At:      h.now(),
+	})
+	if encErr != nil {
+		log.Printf("Processing error encode error: %v", encErr)
+		return
+	}
+	h.publish(message{data: data})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// lockedSource makes a rand.Source safe for the ingest workers to share.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// newRand returns the simulator's random source. Only one goroutine
+// simulates at a time, uniform or Poisson, and nothing else draws from it,
+// so a seed other than 0 reproduces the same sequence of simulated orders.
+// A seed of 0 seeds from the clock.
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// newSampleRand returns the latency sampling source, shared by the ingest
+// workers. They draw in whatever order they are scheduled, so seeding it
+// couldn't make sampling reproducible; it is always seeded from the clock.
+func newSampleRand() *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano()).(rand.Source64)})
+}
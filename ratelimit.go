@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// limiterIdle is how long a client's limiter is kept after its last request.
+const limiterIdle = 3 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter applies a token bucket per client IP. Idle buckets are swept
+// so IP churn doesn't grow the map without bound.
+type ipRateLimiter struct {
+	mu        sync.Mutex
+	rate      rate.Limit
+	burst     int
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+// newIPRateLimiter returns nil, which limits nothing, for a zero rate.
+func newIPRateLimiter(perSecond float64, burst int) *ipRateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &ipRateLimiter{
+		rate:    rate.Limit(perSecond),
+		burst:   burst,
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+// reserve takes a token for ip, returning how long to wait when none is left.
+func (l *ipRateLimiter) reserve(ip string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > limiterIdle {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > limiterIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.clients[ip] = c
+	}
+	c.lastSeen = now
+
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+// limit answers 429 with Retry-After to clients over their rate. It is a
+// no-op on a nil limiter.
+func (l *ipRateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if delay, ok := l.reserve(ip, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maxReplayGap caps the wait between two replayed orders, so a quiet night
+// in the history doesn't stall a demo.
+const maxReplayGap = 10 * time.Second
+
+var replaySeq atomic.Uint64
+
+// Replay is the response of POST /replay
+type Replay struct {
+	ID     string  `json:"id"`
+	Orders int     `json:"orders"`
+	Speed  float64 `json:"speed"`
+}
+
+// handleReplay re-broadcasts the persisted order history, oldest first, at
+// speed times the pace of the original timestamps. It returns at once with
+// the job ID, which tags every replayed message so clients can keep replays
+// apart from live data. Replayed orders are only broadcast, never recorded.
+func handleReplay(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	speed := 1.0
+	if param := r.URL.Query().Get("speed"); param != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(param, 64); err != nil || speed <= 0 {
+			writeError(w, http.StatusBadRequest, "speed must be a positive number")
+			return
+		}
+	}
+
+	orders, err := hub.history.recent(r.Context(), historyLength)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].Timestamp.Before(orders[j].Timestamp)
+	})
+
+	job := Replay{ID: fmt.Sprintf("replay_%d", replaySeq.Add(1)), Orders: len(orders), Speed: speed}
+	go hub.replay(job, orders)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// replay streams the orders to the /ws/orders clients with their original
+// spacing scaled by the job's speed. They are not live orders, so they carry
+// no sequence number to acknowledge.
+func (h *Hub) replay(job Replay, orders []Order) {
+	log.Printf("Replay %s started: %d orders at %gx", job.ID, len(orders), job.Speed)
+	for i, order := range orders {
+		if i > 0 {
+			gap := time.Duration(float64(order.Timestamp.Sub(orders[i-1].Timestamp)) / job.Speed)
+			if gap > maxReplayGap {
+				gap = maxReplayGap
+			}
+			select {
+			case <-h.done:
+				return
+			case <-time.After(gap):
+			}
+		}
+		data, err := json.Marshal(order)
+		if err == nil {
+			data, err = json.Marshal(Envelope{Type: eventOrder, Replay: job.ID, Data: data})
+		}
+		if err != nil {
+			log.Printf("Replay %s encode error: %v", job.ID, err)
+			continue
+		}
+		h.publish(message{data: data, status: order.Status, region: order.Region, stream: streamOrders})
+	}
+	log.Printf("Replay %s finished", job.ID)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReplayStreamsToOrderClients(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.history.ping()
+	for i, id := range []string{"1", "2"} {
+		order := testOrder(id, StatusCompleted, 10)
+		order.Timestamp = testNow.Add(time.Duration(i) * time.Second)
+		hub.recordOrder(order, testNow, 0)
+	}
+	persistPending(hub)
+
+	base := serveTestHub(t, hub)
+	stats := dialTestHub(t, base+"/ws")
+	orders := dialTestHub(t, base+"/ws/orders")
+	readEnvelope(t, stats) // initial snapshot
+	waitFor(t, "both clients to register", func() bool { return hub.ConnectionCount() == 2 })
+
+	rec := httptest.NewRecorder()
+	handleReplay(hub, rec, httptest.NewRequest(http.MethodPost, "/replay?speed=1000", nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+	var job Replay
+	decodeJSON(t, rec, &job)
+
+	for _, id := range []string{"1", "2"} {
+		env := readEnvelope(t, orders)
+		var order Order
+		if err := json.Unmarshal(env.Data, &order); err != nil {
+			t.Fatal(err)
+		}
+		if env.Type != eventOrder || env.Replay != job.ID || env.Seq != 0 || order.ID != id {
+			t.Errorf("/ws/orders got %s %q seq %d order %s, want replayed order %s of %s", env.Type, env.Replay, env.Seq, order.ID, id, job.ID)
+		}
+	}
+
+	// Stats clients get no per-order events
+	publishStatsMarker(t, hub)
+	if events := readUntilStats(t, stats); len(events) != 0 {
+		t.Errorf("/ws got %+v during the replay, want nothing", events)
+	}
+}
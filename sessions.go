@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// session is a run of orders by one customer with no gap longer than the
+// session gap between consecutive orders
+type session struct {
+	customer string
+	orders   int
+	value    float64
+}
+
+// groupSessions splits the orders into per-customer sessions.
+func groupSessions(orders []Order, gap time.Duration) []session {
+	byCustomer := make(map[string][]Order)
+	for _, order := range orders {
+		byCustomer[order.Customer] = append(byCustomer[order.Customer], order)
+	}
+
+	var sessions []session
+	for customer, customerOrders := range byCustomer {
+		sort.Slice(customerOrders, func(i, j int) bool {
+			return customerOrders[i].Timestamp.Before(customerOrders[j].Timestamp)
+		})
+		current := session{customer: customer}
+		var last time.Time
+		for _, order := range customerOrders {
+			if current.orders > 0 && order.Timestamp.Sub(last) > gap {
+				sessions = append(sessions, current)
+				current = session{customer: customer}
+			}
+			current.orders++
+			current.value += order.Amount
+			last = order.Timestamp
+		}
+		sessions = append(sessions, current)
+	}
+	return sessions
+}
+
+// BasketSizes counts sessions by number of orders
+type BasketSizes struct {
+	One       int `json:"1"`
+	Two       int `json:"2"`
+	ThreePlus int `json:"3+"`
+}
+
+// BasketDistribution is the response of /api/sessions/basket-distribution.
+// AverageBasketValue is null when the window holds no sessions.
+type BasketDistribution struct {
+	Window             string      `json:"window"`
+	Sessions           int         `json:"sessions"`
+	Distribution       BasketSizes `json:"distribution"`
+	AverageBasketValue *float64    `json:"average_basket_value"`
+	Reason             string      `json:"reason,omitempty"`
+}
+
+func basketDistribution(sessions []session, window time.Duration) BasketDistribution {
+	result := BasketDistribution{Window: window.String(), Sessions: len(sessions)}
+	if len(sessions) == 0 {
+		result.Reason = "no sessions in the window"
+		return result
+	}
+
+	total := 0.0
+	for _, s := range sessions {
+		switch s.orders {
+		case 1:
+			result.Distribution.One++
+		case 2:
+			result.Distribution.Two++
+		default:
+			result.Distribution.ThreePlus++
+		}
+		total += s.value
+	}
+	average := total / float64(len(sessions))
+	result.AverageBasketValue = &average
+	return result
+}
+
+func handleBasketDistribution(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	window, ok := parseWindow(r, time.Hour)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "window must be a positive duration")
+		return
+	}
+
+	end := hub.now()
+	sessions := groupSessions(hub.orders.between(end.Add(-window), end), hub.sessionGap)
+	writeJSON(w, http.StatusOK, basketDistribution(sessions, window))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getBasketDistribution(t *testing.T, hub *Hub, query string) BasketDistribution {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handleBasketDistribution(hub, rec, httptest.NewRequest(http.MethodGet, "/api/sessions/basket-distribution"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var dist BasketDistribution
+	decodeJSON(t, rec, &dist)
+	return dist
+}
+
+func TestBasketDistribution(t *testing.T) {
+	hub, _ := newTestHub(t, "--session-gap", "10m")
+	for _, order := range []Order{
+		// alice shops three times in a row, then once more after a break
+		customerOrder("1", "alice", 100*time.Minute),
+		customerOrder("2", "alice", 95*time.Minute),
+		customerOrder("3", "alice", 90*time.Minute),
+		customerOrder("4", "alice", 20*time.Minute),
+		customerOrder("5", "bob", 50*time.Minute),
+		customerOrder("6", "bob", 45*time.Minute),
+		customerOrder("7", "carol", 30*time.Minute),
+		// Outside the window
+		customerOrder("8", "dave", 3*time.Hour),
+	} {
+		order.Amount = 10
+		if order.Customer == "carol" {
+			order.Amount = 50
+		}
+		hub.recordOrder(order, order.Timestamp, 0)
+	}
+
+	dist := getBasketDistribution(t, hub, "?window=2h")
+	if dist.Window != "2h0m0s" || dist.Sessions != 4 {
+		t.Errorf("got %d sessions over %s, want 4 over 2h", dist.Sessions, dist.Window)
+	}
+	if want := (BasketSizes{One: 2, Two: 1, ThreePlus: 1}); dist.Distribution != want {
+		t.Errorf("distribution = %+v, want %+v", dist.Distribution, want)
+	}
+	// (30 + 10 + 20 + 50) / 4 sessions
+	if dist.AverageBasketValue == nil || *dist.AverageBasketValue != 27.5 {
+		t.Errorf("average basket value = %v, want 27.5", dist.AverageBasketValue)
+	}
+}
+
+func TestBasketDistributionNoSessions(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.recordOrder(customerOrder("1", "alice", 2*time.Hour), testNow, 0)
+
+	dist := getBasketDistribution(t, hub, "")
+	if dist.Sessions != 0 || dist.AverageBasketValue != nil || dist.Reason == "" {
+		t.Errorf("got %+v, want no sessions, a null average and a reason", dist)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
+)
+
+// Order sinks selectable with --sink
+const (
+	sinkRedis = "redis"
+	sinkKafka = "kafka"
+)
+
+// OrderSink is where the monitor publishes the orders it simulates or
+// receives over HTTP, for other instances and downstream consumers
+type OrderSink interface {
+	Publish(ctx context.Context, order Order) error
+	Close() error
+}
+
+// newOrderSink builds the sink selected in the config.
+func newOrderSink(cfg Config, rdb redis.UniversalClient) OrderSink {
+	if cfg.Sink == sinkKafka {
+		return newKafkaSink(cfg.KafkaBrokers, cfg.KafkaTopic)
+	}
+	return &redisSink{redis: rdb}
+}
+
+// publishOrder publishes an order to the sink, bounded by the publish
+// timeout so a hung broker cannot stall the caller. Failures are logged and
+// counted; the order is still recorded locally.
+func (h *Hub) publishOrder(ctx context.Context, order Order) {
+	ctx, cancel := context.WithTimeout(ctx, h.publishTimeout)
+	defer cancel()
+
+	h.anonymizer.anonymize(&order)
+	if err := h.sink.Publish(ctx, order); err != nil {
+		orderPublishErrors.Inc()
+		log.Printf("Publishing order %s failed: %v", order.ID, err)
+		h.reportProcessingError(errorPublish, order.ID, err)
+	}
+}
+
+// redisSink publishes each order on its tenant's Redis channel, where the
+// other instances' subscribers pick it up
+type redisSink struct {
+	redis redis.UniversalClient
+}
+
+func (s *redisSink) Publish(ctx context.Context, order Order) error {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return s.redis.Publish(ctx, order.Tenant, data).Err()
+}
+
+// Close is a no-op: the client is shared with the hub.
+func (s *redisSink) Close() error {
+	return nil
+}
+
+// kafkaSink writes orders to a Kafka topic, keyed by customer so each
+// customer's orders stay in order on one partition
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func newKafkaSink(brokers []string, topic string) *kafkaSink {
+	return &kafkaSink{writer: &kafka.Writer{
+		Addr:     kafka.TCP(brokers...),
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	}}
+}
+
+func (s *kafkaSink) Publish(ctx context.Context, order Order) error {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	if err := s.writer.WriteMessages(ctx, kafka.Message{Key: []byte(order.Customer), Value: data}); err != nil {
+		return fmt.Errorf("kafka write: %w", err)
+	}
+	return nil
+}
+
+// Close flushes pending writes.
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxSLABreaches bounds the breach list served by /api/sla/breaches.
+const maxSLABreaches = 100
+
+// maxTrackedOrders bounds how many in-flight orders we remember the start
+// time of while waiting for them to complete.
+const maxTrackedOrders = 10000
+
+// SLABreach describes a completed order that exceeded its SLA
+type SLABreach struct {
+	ID             string    `json:"id"`
+	Customer       string    `json:"customer"`
+	SLASeconds     float64   `json:"sla_seconds"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	CompletedAt    time.Time `json:"completed_at"`
+}
+
+// SLAReport is the response of /api/sla/breaches
+type SLAReport struct {
+	Evaluated  int         `json:"evaluated"`
+	Breached   int         `json:"breached"`
+	BreachRate float64     `json:"breach_rate"`
+	Breaches   []SLABreach `json:"breaches"`
+}
+
+// slaTracker times orders from their first sighting to completion and
+// records those that took longer than their SLA
+type slaTracker struct {
+	mu        sync.Mutex
+	started   map[string]time.Time
+	evaluated int
+	breached  int
+	breaches  []SLABreach
+}
+
+func newSLATracker() *slaTracker {
+	return &slaTracker{started: make(map[string]time.Time)}
+}
+
+// observe records a status transition seen at the given time. Orders first
+// seen already completed are timed from their own timestamp.
+func (t *slaTracker) observe(order Order, at time.Time) {
+	if order.SLASeconds <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	start, seen := t.started[order.ID]
+	if order.Status != StatusCompleted {
+		if !seen {
+			t.trackLocked(order.ID, order.Timestamp)
+		}
+		return
+	}
+	if seen {
+		delete(t.started, order.ID)
+	} else {
+		start = order.Timestamp
+	}
+
+	t.evaluated++
+	elapsed := at.Sub(start).Seconds()
+	if elapsed <= order.SLASeconds {
+		return
+	}
+	t.breached++
+	if len(t.breaches) == maxSLABreaches {
+		t.breaches = t.breaches[1:]
+	}
+	t.breaches = append(t.breaches, SLABreach{
+		ID:             order.ID,
+		Customer:       order.Customer,
+		SLASeconds:     order.SLASeconds,
+		ElapsedSeconds: elapsed,
+		CompletedAt:    at,
+	})
+}
+
+func (t *slaTracker) trackLocked(id string, start time.Time) {
+	if len(t.started) >= maxTrackedOrders {
+		// Forget an arbitrary order rather than growing without bound
+		for evict := range t.started {
+			delete(t.started, evict)
+			break
+		}
+	}
+	t.started[id] = start
+}
+
+// reset forgets the tracked orders and every evaluation.
+func (t *slaTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.started = make(map[string]time.Time)
+	t.evaluated, t.breached = 0, 0
+	t.breaches = nil
+}
+
+func (t *slaTracker) report() SLAReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := SLAReport{
+		Evaluated: t.evaluated,
+		Breached:  t.breached,
+		Breaches:  append([]SLABreach{}, t.breaches...),
+	}
+	if t.evaluated > 0 {
+		report.BreachRate = float64(t.breached) / float64(t.evaluated)
+	}
+	return report
+}
+
+func handleSLABreaches(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, hub.sla.report())
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slaOrder returns an order of the given status that must complete within
+// sla seconds.
+func slaOrder(id string, status OrderStatus, sla float64) Order {
+	order := testOrder(id, status, 10)
+	order.SLASeconds = sla
+	return order
+}
+
+func TestSLABreaches(t *testing.T) {
+	hub, _ := newTestHub(t)
+
+	// express-met completes after 30s of a 60s SLA, express-missed after 90s
+	hub.recordOrder(slaOrder("express-met", StatusPending, 60), testNow, 0)
+	hub.recordOrder(slaOrder("express-missed", StatusPending, 60), testNow, 0)
+	hub.recordOrder(slaOrder("express-met", StatusProcessing, 60), testNow.Add(10*time.Second), 0)
+	hub.recordOrder(slaOrder("express-met", StatusCompleted, 60), testNow.Add(30*time.Second), 0)
+	hub.recordOrder(slaOrder("express-missed", StatusCompleted, 60), testNow.Add(90*time.Second), 0)
+	// Orders without an SLA are not evaluated
+	hub.recordOrder(slaOrder("standard", StatusPending, 0), testNow, 0)
+	hub.recordOrder(slaOrder("standard", StatusCompleted, 0), testNow.Add(time.Hour), 0)
+
+	rec := httptest.NewRecorder()
+	handleSLABreaches(hub, rec, httptest.NewRequest(http.MethodGet, "/api/sla/breaches", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var report SLAReport
+	decodeJSON(t, rec, &report)
+
+	if report.Evaluated != 2 || report.Breached != 1 || report.BreachRate != 0.5 {
+		t.Errorf("evaluated %d, breached %d, rate %v; want 2, 1, 0.5", report.Evaluated, report.Breached, report.BreachRate)
+	}
+	if len(report.Breaches) != 1 {
+		t.Fatalf("breaches = %+v, want express-missed only", report.Breaches)
+	}
+	breach := report.Breaches[0]
+	if breach.ID != "express-missed" || breach.SLASeconds != 60 || breach.ElapsedSeconds != 90 {
+		t.Errorf("breach = %+v, want express-missed taking 90s of 60s", breach)
+	}
+	if !breach.CompletedAt.Equal(testNow.Add(90 * time.Second)) {
+		t.Errorf("completed_at = %v, want %v", breach.CompletedAt, testNow.Add(90*time.Second))
+	}
+}
+
+func TestSLAFirstSeenCompleted(t *testing.T) {
+	tracker := newSLATracker()
+
+	// Timed from the order's own timestamp when no earlier status was seen
+	tracker.observe(slaOrder("met", StatusCompleted, 60), testNow.Add(time.Minute))
+	tracker.observe(slaOrder("missed", StatusCompleted, 60), testNow.Add(time.Minute+time.Second))
+
+	report := tracker.report()
+	if report.Evaluated != 2 || report.Breached != 1 {
+		t.Fatalf("evaluated %d, breached %d; want 2, 1", report.Evaluated, report.Breached)
+	}
+	if report.Breaches[0].ID != "missed" {
+		t.Errorf("breached %s, want missed", report.Breaches[0].ID)
+	}
+}
+
+func TestSLAReportEmpty(t *testing.T) {
+	report := newSLATracker().report()
+	if report.BreachRate != 0 || report.Breaches == nil {
+		t.Errorf("empty report = %+v, want a zero rate and an empty list", report)
+	}
+}
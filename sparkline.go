@@ -0,0 +1,54 @@
+package main
+
+import "sync"
+
+// maxSparklineLength keeps the per-message sparkline payload small.
+const maxSparklineLength = 100
+
+// intervalCounter counts orders per stats interval and keeps the last few
+// completed intervals for the dashboard sparkline
+type intervalCounter struct {
+	mu      sync.Mutex
+	size    int
+	current int
+	recent  []int
+}
+
+func newIntervalCounter(size int) *intervalCounter {
+	return &intervalCounter{size: size, recent: make([]int, 0, size)}
+}
+
+func (c *intervalCounter) inc() {
+	c.mu.Lock()
+	c.current++
+	c.mu.Unlock()
+}
+
+// roll closes the current interval, dropping the oldest once full.
+func (c *intervalCounter) roll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.recent) == c.size {
+		copy(c.recent, c.recent[1:])
+		c.recent = c.recent[:c.size-1]
+	}
+	c.recent = append(c.recent, c.current)
+	c.current = 0
+}
+
+// reset forgets the current and completed intervals.
+func (c *intervalCounter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.current = 0
+	c.recent = c.recent[:0]
+}
+
+// snapshot returns the completed interval counts, oldest first.
+func (c *intervalCounter) snapshot() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.recent...)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRecentCountsFollowIntervals(t *testing.T) {
+	hub, _ := newTestHub(t, "--sparkline-length", "3")
+	ctx := context.Background()
+
+	if got := hub.generateStats().RecentCounts; len(got) != 0 {
+		t.Fatalf("before the first interval recent_counts = %v, want empty", got)
+	}
+
+	perInterval := []int{2, 0, 5, 1}
+	want := [][]int{{2}, {2, 0}, {2, 0, 5}, {0, 5, 1}}
+	id := 0
+	for i, n := range perInterval {
+		for j := 0; j < n; j++ {
+			id++
+			hub.recordOrder(testOrder(fmt.Sprint(id), StatusPending, 10), testNow, 0)
+		}
+		hub.tick(ctx)
+
+		if got := hub.generateStats().RecentCounts; !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("after interval %d recent_counts = %v, want %v", i+1, got, want[i])
+		}
+	}
+}
+
+func TestIntervalCounterSnapshotIsACopy(t *testing.T) {
+	c := newIntervalCounter(2)
+	c.inc()
+	c.roll()
+
+	snapshot := c.snapshot()
+	snapshot[0] = 99
+	if got := c.snapshot(); got[0] != 1 {
+		t.Errorf("modifying a snapshot changed the counter: %v", got)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// statsWindowBuckets is how many buckets the stats window is divided into,
+// which sets how coarsely orders age out of it.
+const statsWindowBuckets = 60
+
+type totalsBucket struct {
+	slot    int64
+	orders  int
+	revenue float64
+}
+
+// totalsWindow sums orders and revenue over a sliding window in a
+// time-bucketed ring, so the headline totals reflect recent business rather
+// than everything since start
+type totalsWindow struct {
+	mu      sync.Mutex
+	width   time.Duration
+	buckets []totalsBucket
+}
+
+// newStatsWindow returns the window for the configured length, nil when it
+// is zero and the totals cover all time.
+func newStatsWindow(window time.Duration) *totalsWindow {
+	if window <= 0 {
+		return nil
+	}
+	return newTotalsWindow(window)
+}
+
+func newTotalsWindow(window time.Duration) *totalsWindow {
+	width := window / statsWindowBuckets
+	if width < time.Second {
+		width = time.Second
+	}
+	return newBucketedTotals(width, int((window+width-1)/width))
+}
+
+// newBucketedTotals returns a ring of n buckets of the given width.
+func newBucketedTotals(width time.Duration, n int) *totalsWindow {
+	return &totalsWindow{
+		width:   width,
+		buckets: make([]totalsBucket, n),
+	}
+}
+
+func (w *totalsWindow) add(at time.Time, amount float64) {
+	slot := at.UnixNano() / int64(w.width)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := &w.buckets[int(slot%int64(len(w.buckets)))]
+	if b.slot != slot {
+		*b = totalsBucket{slot: slot}
+	}
+	b.orders++
+	b.revenue += amount
+}
+
+// reset empties the window.
+func (w *totalsWindow) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	clear(w.buckets)
+}
+
+// fill replaces the headline totals with those of the window ending at now.
+func (w *totalsWindow) fill(stats *Stats, now time.Time) {
+	current := now.UnixNano() / int64(w.width)
+	oldest := current - int64(len(w.buckets))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats.TotalOrders, stats.TotalRevenue, stats.AverageOrder = 0, 0, 0
+	for _, b := range w.buckets {
+		if b.slot > oldest && b.slot <= current {
+			stats.TotalOrders += b.orders
+			stats.TotalRevenue += b.revenue
+		}
+	}
+	if stats.TotalOrders > 0 {
+		stats.AverageOrder = stats.TotalRevenue / float64(stats.TotalOrders)
+	}
+}
+
+// series sums the buckets into points buckets of the given width, a multiple
+// of the ring's, ending with the one that contains end. Oldest first.
+func (w *totalsWindow) series(end time.Time, width time.Duration, points int) []RevenuePoint {
+	start := end.Truncate(width).Add(-time.Duration(points-1) * width)
+	current := end.UnixNano() / int64(w.width)
+	oldest := current - int64(len(w.buckets))
+	perPoint := int64(width / w.width)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	series := make([]RevenuePoint, points)
+	for i := range series {
+		bucketStart := start.Add(time.Duration(i) * width)
+		series[i].Timestamp = bucketStart.UTC()
+		first := bucketStart.UnixNano() / int64(w.width)
+		for slot := first; slot < first+perPoint; slot++ {
+			if slot <= oldest || slot > current {
+				continue
+			}
+			if b := w.buckets[int(slot%int64(len(w.buckets)))]; b.slot == slot {
+				series[i].OrderCount += b.orders
+				series[i].Revenue += b.revenue
+			}
+		}
+	}
+	return series
+}
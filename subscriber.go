@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultOrdersChannel is the Redis channel orders are consumed from unless
+// redis-channels says otherwise.
+const defaultOrdersChannel = "orders"
+
+// subscriber records orders published to Redis by other producers, on one
+// channel per tenant
+type subscriber struct {
+	hub    *Hub
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+func newSubscriber(hub *Hub) *subscriber {
+	return &subscriber{
+		hub:    hub,
+		pubsub: hub.redis.Subscribe(context.Background(), hub.channels...),
+		done:   make(chan struct{}),
+	}
+}
+
+// subscriberBuffer is how many received messages may wait to be recorded.
+const subscriberBuffer = 100
+
+// maxResubscribeBackoff caps the wait between attempts to reach Redis.
+const maxResubscribeBackoff = 30 * time.Second
+
+// run consumes messages until ctx is cancelled. On cancellation it closes
+// the subscription and keeps recording the messages already received, so
+// in-flight orders survive a deploy.
+func (s *subscriber) run(ctx context.Context) {
+	defer close(s.done)
+
+	messages := make(chan *redis.Message, subscriberBuffer)
+	go s.receive(ctx, messages)
+	go func() {
+		<-ctx.Done()
+		if err := s.pubsub.Close(); err != nil {
+			log.Printf("Redis unsubscribe error: %v", err)
+		}
+	}()
+
+	// The channel is closed once the subscription is, after any buffered
+	// messages have been read
+	for msg := range messages {
+		s.handle(msg)
+	}
+	log.Println("Redis subscriber stopped")
+}
+
+// receive reads messages into the channel until the subscription is closed.
+// Connection errors are retried with exponential backoff; each retry
+// reconnects and resubscribes.
+func (s *subscriber) receive(ctx context.Context, messages chan<- *redis.Message) {
+	defer close(messages)
+
+	backoff := time.Second
+	for {
+		msg, err := s.pubsub.ReceiveMessage(context.Background())
+		if err == redis.ErrClosed {
+			return
+		}
+		if err != nil {
+			log.Printf("Redis subscription error, reconnecting in %s: %v", backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxResubscribeBackoff {
+				backoff = maxResubscribeBackoff
+			}
+			continue
+		}
+		if backoff > time.Second {
+			log.Println("Redis subscription restored")
+			backoff = time.Second
+		}
+		messages <- msg
+	}
+}
+
+// wait blocks until the subscriber has drained or ctx expires.
+func (s *subscriber) wait(ctx context.Context) {
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		log.Println("Redis subscriber did not drain within the shutdown timeout")
+	}
+}
+
+func (s *subscriber) handle(msg *redis.Message) {
+	defer recoverPanic("subscriber")
+
+	// Unknown statuses are left to the unknown-status policy
+	order, err := decodeOrderAnyStatus([]byte(msg.Payload))
+	if err != nil {
+		log.Printf("Dropping malformed order from Redis: %v", err)
+		s.hub.reportProcessingError(errorUnmarshal, "", err)
+		s.hub.deadLetter(errorUnmarshal, []byte(msg.Payload), err)
+		return
+	}
+	if order.ProcessedBy == s.hub.nodeID {
+		// Published by our own simulator, already recorded
+		return
+	}
+	order.Tenant = msg.Channel
+	// Waiting for room pushes back on Redis rather than dropping orders
+	s.hub.workers.enqueue(context.Background(), ingestItem{order: order, at: time.Now()})
+}
+
+// knownTenant reports whether tenant is one of the consumed channels.
+func (h *Hub) knownTenant(tenant string) bool {
+	for _, channel := range h.channels {
+		if channel == tenant {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSubscriberDrainsOnShutdown(t *testing.T) {
+	const (
+		queued  = 5
+		ordered = 20
+	)
+
+	hub, mr := newTestHub(t, "--order-queue-size", fmt.Sprint(queued))
+	sub := newSubscriber(hub)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sub.run(ctx)
+	waitFor(t, "the subscription", func() bool { return mr.PubSubNumSub(defaultOrdersChannel)[defaultOrdersChannel] == 1 })
+
+	for i := 1; i <= ordered; i++ {
+		payload, err := json.Marshal(testOrder(fmt.Sprint(i), StatusCompleted, 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mr.Publish(defaultOrdersChannel, string(payload))
+	}
+
+	// No workers run yet, so once the queue fills the remaining orders wait
+	// in the subscriber's buffer
+	waitFor(t, "the order queue to fill", func() bool { return hub.workers.depth() == queued })
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	waitFor(t, "the unsubscribe", func() bool { return mr.PubSubNumSub(defaultOrdersChannel)[defaultOrdersChannel] == 0 })
+	hub.workers.start(hub, 2)
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer waitCancel()
+	sub.wait(waitCtx)
+	select {
+	case <-sub.done:
+	default:
+		t.Fatal("subscriber did not drain")
+	}
+	if !hub.workers.shutdown(waitCtx) {
+		t.Fatal("workers did not drain")
+	}
+
+	// Subscribed orders are timed by the wall clock, not the test hub's, so
+	// count them outside the stats window
+	if got := hub.generateStats().LifetimeOrders; got != ordered {
+		t.Errorf("recorded %d orders, want all %d received before shutdown", got, ordered)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// seriesCapacity bounds the snapshot history: a day of samples at the
+// default 2 second stats tick.
+const seriesCapacity = 43200
+
+// maxSeriesPoints caps how many buckets a single timeseries query may return.
+const maxSeriesPoints = 1000
+
+// minSeriesGranularity is the finest bucket width a client may ask for.
+const minSeriesGranularity = time.Second
+
+// seriesMetrics maps the public metric names to their Stats fields.
+var seriesMetrics = map[string]func(seriesSample) float64{
+	"orders":     func(s seriesSample) float64 { return s.orders },
+	"revenue":    func(s seriesSample) float64 { return s.revenue },
+	"error_rate": func(s seriesSample) float64 { return s.errorRate },
+	"active":     func(s seriesSample) float64 { return s.active },
+}
+
+// seriesSample is the subset of a Stats snapshot that is charted over time
+type seriesSample struct {
+	at        time.Time
+	orders    float64
+	revenue   float64
+	errorRate float64
+	active    float64
+}
+
+// SeriesPoint is a single bucket in a timeseries response
+type SeriesPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// seriesStore retains periodic stats snapshots in a fixed-size ring
+type seriesStore struct {
+	mu      sync.RWMutex
+	samples []seriesSample
+	next    int
+	full    bool
+}
+
+func newSeriesStore(capacity int) *seriesStore {
+	return &seriesStore{samples: make([]seriesSample, capacity)}
+}
+
+// add records a snapshot taken at the given time. Snapshots must be added in
+// chronological order.
+func (s *seriesStore) add(at time.Time, stats Stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples[s.next] = seriesSample{
+		at:        at,
+		orders:    float64(stats.TotalOrders),
+		revenue:   stats.TotalRevenue,
+		errorRate: stats.ErrorRate,
+		active:    float64(stats.ActiveOrders),
+	}
+	s.next = (s.next + 1) % len(s.samples)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// reset forgets every retained snapshot.
+func (s *seriesStore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.samples)
+	s.next, s.full = 0, false
+}
+
+// ordered returns the retained snapshots oldest first.
+func (s *seriesStore) ordered() []seriesSample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.full {
+		return append([]seriesSample(nil), s.samples[:s.next]...)
+	}
+	out := make([]seriesSample, 0, len(s.samples))
+	out = append(out, s.samples[s.next:]...)
+	return append(out, s.samples[:s.next]...)
+}
+
+// query returns points buckets of the given width ending with the bucket that
+// contains end. Each bucket holds the last snapshot value recorded within it;
+// empty buckets carry the previous value forward.
+func (s *seriesStore) query(metric string, end time.Time, granularity time.Duration, points int) []SeriesPoint {
+	extract := seriesMetrics[metric]
+	samples := s.ordered()
+
+	start := end.Truncate(granularity).Add(-time.Duration(points-1) * granularity)
+	idx := 0
+	value := 0.0
+	for idx < len(samples) && samples[idx].at.Before(start) {
+		value = extract(samples[idx])
+		idx++
+	}
+
+	series := make([]SeriesPoint, 0, points)
+	for i := 0; i < points; i++ {
+		bucketStart := start.Add(time.Duration(i) * granularity)
+		bucketEnd := bucketStart.Add(granularity)
+		for idx < len(samples) && samples[idx].at.Before(bucketEnd) {
+			value = extract(samples[idx])
+			idx++
+		}
+		series = append(series, SeriesPoint{Timestamp: bucketStart.UTC(), Value: value})
+	}
+	return series
+}
+
+// parseSeriesRange validates the bucket width and window query parameters
+// and returns the bucket width and number of points they describe. name is
+// the width parameter's name, for the error messages.
+func parseSeriesRange(name, granularityParam, windowParam string) (time.Duration, int, error) {
+	granularity, err := time.ParseDuration(granularityParam)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid %s %q", name, granularityParam)
+	}
+	if granularity < minSeriesGranularity {
+		return 0, 0, fmt.Errorf("%s must be at least %s", name, minSeriesGranularity)
+	}
+
+	window, err := time.ParseDuration(windowParam)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid window %q", windowParam)
+	}
+	if window < granularity || window%granularity != 0 {
+		return 0, 0, fmt.Errorf("window must be a whole multiple of %s", name)
+	}
+
+	points := int(window / granularity)
+	if points > maxSeriesPoints {
+		return 0, 0, fmt.Errorf("window/%s yields %d points, maximum is %d", name, points, maxSeriesPoints)
+	}
+	return granularity, points, nil
+}
+
+func handleTimeseries(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if _, ok := seriesMetrics[metric]; !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown metric %q (want orders, revenue, error_rate or active)", metric))
+		return
+	}
+
+	granularityParam := q.Get("granularity")
+	if granularityParam == "" {
+		granularityParam = "1m"
+	}
+	windowParam := q.Get("window")
+	if windowParam == "" {
+		windowParam = "1h"
+	}
+	granularity, points, err := parseSeriesRange("granularity", granularityParam, windowParam)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, hub.series.query(metric, hub.now(), granularity, points))
+}
+
+// revenueSeriesRetention is how far back /stats/timeseries reaches; longer
+// windows are clamped to it.
+const revenueSeriesRetention = 24 * time.Hour
+
+// RevenuePoint is one bucket of /stats/timeseries
+type RevenuePoint struct {
+	Timestamp  time.Time `json:"timestamp"`
+	OrderCount int       `json:"order_count"`
+	Revenue    float64   `json:"revenue"`
+}
+
+// newRevenueSeries returns the per-second order and revenue totals behind
+// /stats/timeseries.
+func newRevenueSeries() *totalsWindow {
+	return newBucketedTotals(minSeriesGranularity, int(revenueSeriesRetention/minSeriesGranularity))
+}
+
+// handleRevenueSeries serves order counts and revenue summed per bucket, for
+// charting without Prometheus.
+func handleRevenueSeries(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	bucketParam := q.Get("bucket")
+	if bucketParam == "" {
+		bucketParam = "1m"
+	}
+	windowParam := q.Get("window")
+	if windowParam == "" {
+		windowParam = "1h"
+	}
+	bucket, points, err := parseSeriesRange("bucket", bucketParam, windowParam)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if retained := int(revenueSeriesRetention / bucket); points > retained {
+		points = retained
+	}
+
+	writeJSON(w, http.StatusOK, hub.revenueSeries.series(hub.now(), bucket, points))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// seedSeries records a snapshot every minute for the last n minutes before
+// testNow, numbered 1 to n oldest first, in every charted field.
+func seedSeries(hub *Hub, n int) {
+	for i := 1; i <= n; i++ {
+		at := testNow.Add(-time.Duration(n-i) * time.Minute)
+		hub.series.add(at, Stats{
+			TotalOrders:  i,
+			TotalRevenue: float64(i) * 10,
+			ErrorRate:    float64(i) / 100,
+			ActiveOrders: i * 2,
+		})
+	}
+}
+
+func TestSeriesQueryValues(t *testing.T) {
+	hub, _ := newTestHub(t)
+	seedSeries(hub, 10)
+
+	tests := []struct {
+		metric string
+		want   func(i int) float64
+	}{
+		{"orders", func(i int) float64 { return float64(i) }},
+		{"revenue", func(i int) float64 { return float64(i) * 10 }},
+		{"error_rate", func(i int) float64 { return float64(i) / 100 }},
+		{"active", func(i int) float64 { return float64(i * 2) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metric, func(t *testing.T) {
+			series := hub.series.query(tt.metric, testNow, time.Minute, 5)
+			if len(series) != 5 {
+				t.Fatalf("got %d points, want 5", len(series))
+			}
+			for j, point := range series {
+				// The last five minutes hold snapshots 6 to 10
+				if want := tt.want(6 + j); point.Value != want {
+					t.Errorf("point %d = %v, want %v", j, point.Value, want)
+				}
+				if want := testNow.Add(-time.Duration(4-j) * time.Minute); !point.Timestamp.Equal(want) {
+					t.Errorf("point %d at %v, want %v", j, point.Timestamp, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSeriesQueryCarriesValuesForward(t *testing.T) {
+	hub, _ := newTestHub(t)
+	hub.series.add(testNow.Add(-10*time.Minute), Stats{TotalOrders: 3})
+	hub.series.add(testNow.Add(-2*time.Minute), Stats{TotalOrders: 7})
+
+	series := hub.series.query("orders", testNow, time.Minute, 5)
+	want := []float64{3, 3, 7, 7, 7}
+	for i, point := range series {
+		if point.Value != want[i] {
+			t.Errorf("point %d = %v, want %v", i, point.Value, want[i])
+		}
+	}
+}
+
+func TestSeriesQueryCoarserBucketsKeepLastValue(t *testing.T) {
+	hub, _ := newTestHub(t)
+	seedSeries(hub, 10)
+
+	// Five-minute buckets ending with testNow's: 12:00 holds only snapshot
+	// 10, 11:55 holds snapshots 5 to 9
+	series := hub.series.query("orders", testNow, 5*time.Minute, 2)
+	if series[0].Value != 9 || series[1].Value != 10 {
+		t.Errorf("series = %+v, want values 9 and 10", series)
+	}
+}
+
+func TestHandleTimeseriesLength(t *testing.T) {
+	hub, _ := newTestHub(t)
+	seedSeries(hub, 90)
+
+	tests := []struct {
+		granularity, window string
+		want                int
+	}{
+		{"", "", 60},
+		{"1m", "1h", 60},
+		{"30s", "10m", 20},
+		{"5m", "1h", 12},
+		{"1h", "1h", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.granularity+"/"+tt.window, func(t *testing.T) {
+			url := fmt.Sprintf("/api/timeseries?metric=orders&granularity=%s&window=%s", tt.granularity, tt.window)
+			rec := httptest.NewRecorder()
+			handleTimeseries(hub, rec, httptest.NewRequest(http.MethodGet, url, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var series []SeriesPoint
+			decodeJSON(t, rec, &series)
+			if len(series) != tt.want {
+				t.Errorf("got %d points, want %d", len(series), tt.want)
+			}
+			if last := series[len(series)-1]; last.Value != 90 {
+				t.Errorf("last point = %v, want the latest snapshot, 90", last.Value)
+			}
+		})
+	}
+}
+
+func TestHandleTimeseriesValidation(t *testing.T) {
+	hub, _ := newTestHub(t)
+
+	for _, query := range []string{
+		"metric=latency",
+		"",
+		"metric=orders&granularity=soon",
+		"metric=orders&granularity=500ms",
+		"metric=orders&granularity=1m&window=later",
+		"metric=orders&granularity=7m&window=1h",
+		"metric=orders&granularity=1h&window=1m",
+		"metric=orders&granularity=1s&window=1h",
+	} {
+		rec := httptest.NewRecorder()
+		handleTimeseries(hub, rec, httptest.NewRequest(http.MethodGet, "/api/timeseries?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
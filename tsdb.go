@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tsdbMeasurement is the line protocol measurement stats are written under.
+const tsdbMeasurement = "order_stats"
+
+// statsPoint is a stats snapshot waiting to be exported
+type statsPoint struct {
+	at    time.Time
+	stats Stats
+}
+
+// tsdbExporter writes stats snapshots to a time-series database using the
+// InfluxDB line protocol. Writes happen on a background goroutine fed by a
+// bounded queue, so a slow or unreachable database never delays broadcasts;
+// points that don't fit in the queue are dropped and counted.
+type tsdbExporter struct {
+	url    string
+	token  string
+	client *http.Client
+	queue  chan statsPoint
+}
+
+// newTSDBExporter returns nil when no TSDB endpoint is configured.
+func newTSDBExporter(cfg Config) *tsdbExporter {
+	if cfg.TSDBURL == "" {
+		return nil
+	}
+	return &tsdbExporter{
+		url:    cfg.TSDBURL,
+		token:  cfg.TSDBToken,
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan statsPoint, cfg.TSDBQueueSize),
+	}
+}
+
+// enqueue schedules a snapshot for export without blocking. It is a no-op on
+// a nil exporter.
+func (e *tsdbExporter) enqueue(at time.Time, stats Stats) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.queue <- statsPoint{at: at, stats: stats}:
+	default:
+		tsdbPointsDropped.Inc()
+	}
+}
+
+func (e *tsdbExporter) run() {
+	for point := range e.queue {
+		if err := e.write(point); err != nil {
+			tsdbWriteErrors.Inc()
+			log.Printf("TSDB write error: %v", err)
+		}
+	}
+}
+
+func (e *tsdbExporter) write(point statsPoint) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, strings.NewReader(statsLine(point.at, point.stats)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// statsLine renders a snapshot as a single line protocol point.
+func statsLine(at time.Time, stats Stats) string {
+	fields := []string{
+		"total_orders=" + strconv.Itoa(stats.TotalOrders) + "i",
+		"total_revenue=" + strconv.FormatFloat(stats.TotalRevenue, 'f', -1, 64),
+		"active_orders=" + strconv.Itoa(stats.ActiveOrders) + "i",
+		"average_order=" + strconv.FormatFloat(stats.AverageOrder, 'f', -1, 64),
+		"error_rate=" + strconv.FormatFloat(stats.ErrorRate, 'f', -1, 64),
+		"queue_depth=" + strconv.Itoa(stats.QueueDepth) + "i",
+	}
+	return fmt.Sprintf("%s %s %d\n", tsdbMeasurement, strings.Join(fields, ","), at.UnixNano())
+}
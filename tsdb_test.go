@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// capturedWrite is one request received by the stub TSDB
+type capturedWrite struct {
+	auth        string
+	contentType string
+	body        string
+}
+
+// stubTSDB starts an endpoint answering status to every write and an
+// exporter pointed at it.
+func stubTSDB(t *testing.T, status int, args ...string) (*tsdbExporter, <-chan capturedWrite) {
+	t.Helper()
+
+	writes := make(chan capturedWrite, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		writes <- capturedWrite{auth: r.Header.Get("Authorization"), contentType: r.Header.Get("Content-Type"), body: string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg, err := loadConfig(append([]string{"--tsdb-url", srv.URL}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTSDBExporter(cfg), writes
+}
+
+func receiveWrite(t *testing.T, writes <-chan capturedWrite) capturedWrite {
+	t.Helper()
+
+	select {
+	case w := <-writes:
+		return w
+	case <-time.After(2 * time.Second):
+		t.Fatal("no point written")
+		return capturedWrite{}
+	}
+}
+
+func TestTSDBExporterWritesPoints(t *testing.T) {
+	exporter, writes := stubTSDB(t, http.StatusNoContent, "--tsdb-token", "secret")
+	go exporter.run()
+	t.Cleanup(func() { close(exporter.queue) })
+
+	at := time.Unix(1700000000, 5)
+	exporter.enqueue(at, Stats{TotalOrders: 3, TotalRevenue: 12.5, ActiveOrders: 1, AverageOrder: 4.25, ErrorRate: 0.5, QueueDepth: 7})
+	exporter.enqueue(at.Add(time.Second), Stats{})
+
+	w := receiveWrite(t, writes)
+	want := "order_stats total_orders=3i,total_revenue=12.5,active_orders=1i,average_order=4.25,error_rate=0.5,queue_depth=7i 1700000000000000005\n"
+	if w.body != want {
+		t.Errorf("point = %q, want %q", w.body, want)
+	}
+	if w.auth != "Token secret" {
+		t.Errorf("Authorization = %q, want Token secret", w.auth)
+	}
+	if w.contentType != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/plain", w.contentType)
+	}
+
+	w = receiveWrite(t, writes)
+	want = "order_stats total_orders=0i,total_revenue=0,active_orders=0i,average_order=0,error_rate=0,queue_depth=0i 1700000001000000005\n"
+	if w.body != want {
+		t.Errorf("second point = %q, want %q", w.body, want)
+	}
+}
+
+func TestTSDBExporterCountsFailedWrites(t *testing.T) {
+	exporter, writes := stubTSDB(t, http.StatusInternalServerError)
+	before := testutil.ToFloat64(tsdbWriteErrors)
+	go exporter.run()
+	t.Cleanup(func() { close(exporter.queue) })
+
+	exporter.enqueue(testNow, Stats{})
+	receiveWrite(t, writes)
+	waitFor(t, "the write error to be counted", func() bool {
+		return testutil.ToFloat64(tsdbWriteErrors)-before == 1
+	})
+}
+
+func TestTSDBExporterDropsWhenQueueFull(t *testing.T) {
+	exporter, _ := stubTSDB(t, http.StatusNoContent, "--tsdb-queue-size", "2")
+	before := testutil.ToFloat64(tsdbPointsDropped)
+
+	// Nothing drains the queue, and enqueue must still not block
+	for i := 0; i < 5; i++ {
+		exporter.enqueue(testNow, Stats{})
+	}
+	if got := testutil.ToFloat64(tsdbPointsDropped) - before; got != 3 {
+		t.Errorf("dropped %v points, want 3", got)
+	}
+}
+
+func TestTSDBExporterDisabled(t *testing.T) {
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := newTSDBExporter(cfg)
+	if exporter != nil {
+		t.Fatal("exporter created without a TSDB URL")
+	}
+	exporter.enqueue(testNow, Stats{})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// eventStale reports the stats freezing or resuming.
+const eventStale = "stale"
+
+// StaleNotice is broadcast when no order has been recorded for the stale
+// threshold, and again once orders resume
+type StaleNotice struct {
+	Stale      bool      `json:"stale"`
+	LastUpdate time.Time `json:"last_update"`
+	Threshold  float64   `json:"threshold_seconds"`
+}
+
+// markUpdated records that the stats just moved.
+func (h *Hub) markUpdated(at time.Time) {
+	h.lastUpdate.Store(at.UnixNano())
+}
+
+// watchStaleness flips monitor_stats_stale and notifies clients when nothing
+// has updated the stats for the stale threshold, e.g. with Redis down and
+// simulation off, and clears it when updates resume.
+func (h *Hub) watchStaleness(ctx context.Context) {
+	interval := h.staleThreshold / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stale := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		last := time.Unix(0, h.lastUpdate.Load())
+		if now := h.now(); (now.Sub(last) > h.staleThreshold) == stale {
+			continue
+		}
+		stale = !stale
+		if stale {
+			monitorStatsStale.Set(1)
+			log.Printf("Stats stale: no order recorded since %s", last.Format(time.RFC3339))
+		} else {
+			monitorStatsStale.Set(0)
+			log.Println("Stats updating again")
+		}
+		data, err := newEnvelope(eventStale, StaleNotice{Stale: stale, LastUpdate: last, Threshold: h.staleThreshold.Seconds()})
+		if err != nil {
+			log.Printf("Stale notice encode error: %v", err)
+			continue
+		}
+		h.publish(message{data: data})
+	}
+}
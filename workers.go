@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ingestItem is an order waiting for a worker to record it
+type ingestItem struct {
+	order   Order
+	at      time.Time
+	latency time.Duration
+}
+
+// orderWorkers records queued orders on a fixed pool of goroutines. Every
+// ingestion path enqueues onto the same channel, whose length is the queue
+// depth reported in stats.
+type orderWorkers struct {
+	queue chan ingestItem
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newOrderWorkers(queueSize int) *orderWorkers {
+	return &orderWorkers{
+		queue: make(chan ingestItem, queueSize),
+		stop:  make(chan struct{}),
+	}
+}
+
+// start launches n workers recording into the hub.
+func (p *orderWorkers) start(h *Hub, n int) {
+	for i := 0; i < n; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				select {
+				case item := <-p.queue:
+					record(h, item)
+				case <-p.stop:
+					p.drain(h)
+					return
+				}
+			}
+		}()
+	}
+}
+
+// record records one queued order. A panic is logged and counted and only
+// that order is lost, so one bad order cannot take the monitor down.
+func record(h *Hub, item ingestItem) {
+	defer recoverPanic("order worker")
+	h.recordOrder(item.order, item.at, item.latency)
+}
+
+// drain records whatever is still queued.
+func (p *orderWorkers) drain(h *Hub) {
+	for {
+		select {
+		case item := <-p.queue:
+			record(h, item)
+		default:
+			return
+		}
+	}
+}
+
+// shutdown stops the workers once the queue is drained, or when ctx expires.
+// Producers must have stopped; anything enqueued later is not recorded.
+func (p *orderWorkers) shutdown(ctx context.Context) bool {
+	close(p.stop)
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// enqueue waits for room in the queue, giving up when ctx is done.
+func (p *orderWorkers) enqueue(ctx context.Context, item ingestItem) bool {
+	select {
+	case p.queue <- item:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tryEnqueue queues without waiting, reporting false when the queue is full.
+func (p *orderWorkers) tryEnqueue(item ingestItem) bool {
+	select {
+	case p.queue <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *orderWorkers) depth() int {
+	return len(p.queue)
+}